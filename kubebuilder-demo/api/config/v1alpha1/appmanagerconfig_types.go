@@ -0,0 +1,236 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"net"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	metav1validation "k8s.io/apimachinery/pkg/apis/meta/v1/validation"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	cfg "sigs.k8s.io/controller-runtime/pkg/config/v1alpha1"
+)
+
+//+kubebuilder:object:root=true
+
+// AppManagerConfig is the configuration file of the App manager. Besides the
+// controller-runtime options it configures the App controller and webhooks.
+type AppManagerConfig struct {
+	metav1.TypeMeta `json:",inline"`
+
+	// ControllerManagerConfigurationSpec returns the configurations for controllers
+	cfg.ControllerManagerConfigurationSpec `json:",inline"`
+
+	// Namespaces watched by the manager. All namespaces are watched when empty.
+	// +optional
+	Namespaces []string `json:"namespaces,omitempty"`
+
+	// NamespaceSelector restricts the Apps managed by the manager to the
+	// namespaces whose labels match it, e.g. the namespaces of a tenant.
+	// +optional
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+
+	// ProtectedNamespaceSelector selects the production namespaces, whose Apps
+	// can only be deleted once the deletion is confirmed. Defaults to the
+	// namespaces labeled environment=production.
+	// +optional
+	ProtectedNamespaceSelector *metav1.LabelSelector `json:"protectedNamespaceSelector,omitempty"`
+
+	// MaxConcurrentReconciles is the number of Apps reconciled concurrently.
+	// Defaults to 1.
+	// +optional
+	MaxConcurrentReconciles int `json:"maxConcurrentReconciles,omitempty"`
+
+	// UnfilteredWatches are the watches of the App controller whose events
+	// are not filtered, e.g. Deployment to reconcile the Apps on every change
+	// of their Deployments, status included. The watches are App, Dependency,
+	// Deployment, HorizontalPodAutoscaler, PodDisruptionBudget, Ingress and Service.
+	// +optional
+	UnfilteredWatches []string `json:"unfilteredWatches,omitempty"`
+
+	// Ingress configures the defaults of the Ingress of an App.
+	// +optional
+	Ingress IngressConfig `json:"ingress,omitempty"`
+
+	// Certificates enables the webhook serving certificates generated and
+	// rotated by the manager, instead of the certificates of cert-manager.
+	// +optional
+	Certificates *CertificatesConfig `json:"certificates,omitempty"`
+}
+
+// IngressConfig configures the defaults of the Ingress of an App.
+type IngressConfig struct {
+	// Domain used to build the default Ingress host <name>.<domain> of an App.
+	// +optional
+	Domain string `json:"domain,omitempty"`
+
+	// ClassName is the IngressClass used by the Apps that do not set one.
+	// The cluster default class is used when empty.
+	// +optional
+	ClassName string `json:"className,omitempty"`
+}
+
+// CertificatesConfig configures the webhook serving certificates generated by the manager.
+type CertificatesConfig struct {
+	// SecretName is the Secret storing the CA and the serving certificate.
+	SecretName string `json:"secretName"`
+
+	// SecretNamespace is the namespace of the Secret. Defaults to the
+	// namespace of the manager, read from the POD_NAMESPACE environment variable.
+	// +optional
+	SecretNamespace string `json:"secretNamespace,omitempty"`
+
+	// ServiceName is the Service of the webhook server, in the namespace of
+	// the Secret. The certificate is valid for <service>.<namespace>.svc.
+	// +optional
+	ServiceName string `json:"serviceName,omitempty"`
+
+	// Hosts are additional DNS names and IP addresses of the certificate,
+	// e.g. the address of a manager run outside of the cluster.
+	// +optional
+	Hosts []string `json:"hosts,omitempty"`
+
+	// MutatingWebhookConfigurations the CA is injected into.
+	// +optional
+	MutatingWebhookConfigurations []string `json:"mutatingWebhookConfigurations,omitempty"`
+
+	// ValidatingWebhookConfigurations the CA is injected into.
+	// +optional
+	ValidatingWebhookConfigurations []string `json:"validatingWebhookConfigurations,omitempty"`
+
+	// CustomResourceDefinitions whose conversion webhook the CA is injected into.
+	// +optional
+	CustomResourceDefinitions []string `json:"customResourceDefinitions,omitempty"`
+
+	// Validity of the serving certificate. Defaults to one year.
+	// +optional
+	Validity *metav1.Duration `json:"validity,omitempty"`
+
+	// RotateBefore is the time before their expiry at which the certificates
+	// are rotated. Defaults to 30 days.
+	// +optional
+	RotateBefore *metav1.Duration `json:"rotateBefore,omitempty"`
+}
+
+// supportedWatches are the names of the watches of the App controller.
+var supportedWatches = sets.NewString("App", "Dependency", "Deployment", "HorizontalPodAutoscaler", "PodDisruptionBudget", "Ingress", "Service")
+
+// Validate checks the configuration before the manager is started.
+func (c *AppManagerConfig) Validate() error {
+	var allErrs field.ErrorList
+
+	if c.SyncPeriod != nil && c.SyncPeriod.Duration <= 0 {
+		allErrs = append(allErrs, field.Invalid(field.NewPath("syncPeriod"), c.SyncPeriod.Duration.String(), "must be greater than 0"))
+	}
+	if port := c.Webhook.Port; port != nil {
+		for _, msg := range validation.IsValidPortNum(*port) {
+			allErrs = append(allErrs, field.Invalid(field.NewPath("webhook", "port"), *port, msg))
+		}
+	}
+
+	namespacesPath := field.NewPath("namespaces")
+	// cacheNamespace只能监听一个namespace, 与namespaces同时配置时无法确定监听范围
+	if c.CacheNamespace != "" && len(c.Namespaces) > 0 {
+		allErrs = append(allErrs, field.Forbidden(namespacesPath, "may not be set together with cacheNamespace"))
+	}
+	namespaces := sets.NewString()
+	for i, ns := range c.Namespaces {
+		for _, msg := range validation.IsDNS1123Label(ns) {
+			allErrs = append(allErrs, field.Invalid(namespacesPath.Index(i), ns, msg))
+		}
+		if namespaces.Has(ns) {
+			allErrs = append(allErrs, field.Duplicate(namespacesPath.Index(i), ns))
+		}
+		namespaces.Insert(ns)
+	}
+
+	if c.NamespaceSelector != nil {
+		allErrs = append(allErrs, metav1validation.ValidateLabelSelector(c.NamespaceSelector, field.NewPath("namespaceSelector"))...)
+	}
+	if c.ProtectedNamespaceSelector != nil {
+		allErrs = append(allErrs, metav1validation.ValidateLabelSelector(c.ProtectedNamespaceSelector, field.NewPath("protectedNamespaceSelector"))...)
+	}
+
+	if c.MaxConcurrentReconciles < 0 {
+		allErrs = append(allErrs, field.Invalid(field.NewPath("maxConcurrentReconciles"), c.MaxConcurrentReconciles, "must be greater than or equal to 0"))
+	}
+
+	watchesPath := field.NewPath("unfilteredWatches")
+	watches := sets.NewString()
+	for i, watch := range c.UnfilteredWatches {
+		if !supportedWatches.Has(watch) {
+			allErrs = append(allErrs, field.NotSupported(watchesPath.Index(i), watch, supportedWatches.List()))
+		}
+		if watches.Has(watch) {
+			allErrs = append(allErrs, field.Duplicate(watchesPath.Index(i), watch))
+		}
+		watches.Insert(watch)
+	}
+	ingressPath := field.NewPath("ingress")
+	if c.Ingress.Domain != "" {
+		for _, msg := range validation.IsDNS1123Subdomain(c.Ingress.Domain) {
+			allErrs = append(allErrs, field.Invalid(ingressPath.Child("domain"), c.Ingress.Domain, msg))
+		}
+	}
+	if c.Ingress.ClassName != "" {
+		for _, msg := range validation.IsDNS1123Subdomain(c.Ingress.ClassName) {
+			allErrs = append(allErrs, field.Invalid(ingressPath.Child("className"), c.Ingress.ClassName, msg))
+		}
+	}
+	if c.Certificates != nil {
+		allErrs = append(allErrs, validateCertificates(c.Certificates, field.NewPath("certificates"))...)
+	}
+	return allErrs.ToAggregate()
+}
+
+func validateCertificates(certs *CertificatesConfig, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	if certs.SecretName == "" {
+		allErrs = append(allErrs, field.Required(fldPath.Child("secretName"), ""))
+	}
+	for _, msg := range validation.IsDNS1123Subdomain(certs.SecretName) {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("secretName"), certs.SecretName, msg))
+	}
+	if certs.ServiceName == "" && len(certs.Hosts) == 0 {
+		allErrs = append(allErrs, field.Required(fldPath.Child("serviceName"), "either serviceName or hosts must be set"))
+	}
+	for i, host := range certs.Hosts {
+		if net.ParseIP(host) != nil {
+			continue
+		}
+		for _, msg := range validation.IsDNS1123Subdomain(host) {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("hosts").Index(i), host, msg))
+		}
+	}
+	if certs.Validity != nil && certs.Validity.Duration <= 0 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("validity"), certs.Validity.Duration.String(), "must be greater than 0"))
+	}
+	if certs.RotateBefore != nil && certs.RotateBefore.Duration <= 0 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("rotateBefore"), certs.RotateBefore.Duration.String(), "must be greater than 0"))
+	}
+	// 轮换的时间必须早于证书的有效期, 否则每次检查都会重新生成证书
+	if certs.Validity != nil && certs.RotateBefore != nil && certs.RotateBefore.Duration >= certs.Validity.Duration {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("rotateBefore"), certs.RotateBefore.Duration.String(), "must be less than validity"))
+	}
+	return allErrs
+}
+
+func init() {
+	SchemeBuilder.Register(&AppManagerConfig{})
+}
@@ -0,0 +1,89 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/utils/pointer"
+	ctrl "sigs.k8s.io/controller-runtime"
+	cfg "sigs.k8s.io/controller-runtime/pkg/config/v1alpha1"
+)
+
+var _ = Describe("AppManagerConfig", func() {
+	It("loads the config file of the manager", func() {
+		scheme := runtime.NewScheme()
+		Expect(AddToScheme(scheme)).To(Succeed())
+		config := AppManagerConfig{}
+		options, err := ctrl.Options{Scheme: scheme}.AndFrom(
+			ctrl.ConfigFile().AtPath(filepath.Join("..", "..", "..", "config", "manager", "controller_manager_config.yaml")).OfKind(&config))
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(options.LeaderElection).To(BeTrue())
+		Expect(options.LeaderElectionID).To(Equal("94ba189a.baiding.tech"))
+		Expect(options.MetricsBindAddress).To(Equal("127.0.0.1:8080"))
+		Expect(options.Port).To(Equal(9443))
+		Expect(config.MaxConcurrentReconciles).To(Equal(1))
+		Expect(config.Ingress.Domain).To(Equal("baiding.tech"))
+		Expect(config.Validate()).To(Succeed())
+	})
+
+	table.DescribeTable("rejects invalid values",
+		func(config AppManagerConfig, field string) {
+			err := config.Validate()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring(field))
+		},
+		table.Entry("sync period", AppManagerConfig{ControllerManagerConfigurationSpec: cfg.ControllerManagerConfigurationSpec{
+			SyncPeriod: &metav1.Duration{Duration: -time.Minute},
+		}}, "syncPeriod"),
+		table.Entry("webhook port", AppManagerConfig{ControllerManagerConfigurationSpec: cfg.ControllerManagerConfigurationSpec{
+			Webhook: cfg.ControllerWebhook{Port: pointer.Int(70000)},
+		}}, "webhook.port"),
+		table.Entry("namespaces together with cacheNamespace", AppManagerConfig{
+			ControllerManagerConfigurationSpec: cfg.ControllerManagerConfigurationSpec{CacheNamespace: "default"},
+			Namespaces:                         []string{"default"},
+		}, "namespaces"),
+		table.Entry("invalid namespace", AppManagerConfig{Namespaces: []string{"Tenant_A"}}, "namespaces[0]"),
+		table.Entry("duplicate namespace", AppManagerConfig{Namespaces: []string{"a", "a"}}, "namespaces[1]"),
+		table.Entry("namespace selector", AppManagerConfig{NamespaceSelector: &metav1.LabelSelector{
+			MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "tenant", Operator: metav1.LabelSelectorOpIn}},
+		}}, "namespaceSelector.matchExpressions[0].values"),
+		table.Entry("protected namespace selector", AppManagerConfig{ProtectedNamespaceSelector: &metav1.LabelSelector{
+			MatchLabels: map[string]string{"environment": "Production!"},
+		}}, "protectedNamespaceSelector.matchLabels"),
+		table.Entry("negative concurrency", AppManagerConfig{MaxConcurrentReconciles: -1}, "maxConcurrentReconciles"),
+		table.Entry("unknown watch", AppManagerConfig{UnfilteredWatches: []string{"Pod"}}, "unfilteredWatches[0]"),
+		table.Entry("duplicate watch", AppManagerConfig{UnfilteredWatches: []string{"Deployment", "Deployment"}}, "unfilteredWatches[1]"),
+		table.Entry("ingress domain", AppManagerConfig{Ingress: IngressConfig{Domain: "-example.com"}}, "ingress.domain"),
+		table.Entry("certificates without secret", AppManagerConfig{Certificates: &CertificatesConfig{ServiceName: "webhook-service"}}, "certificates.secretName"),
+		table.Entry("certificates without hosts", AppManagerConfig{Certificates: &CertificatesConfig{SecretName: "webhook-cert"}}, "certificates.serviceName"),
+		table.Entry("certificates rotated after expiry", AppManagerConfig{Certificates: &CertificatesConfig{
+			SecretName:   "webhook-cert",
+			ServiceName:  "webhook-service",
+			Validity:     &metav1.Duration{Duration: time.Hour},
+			RotateBefore: &metav1.Duration{Duration: 2 * time.Hour},
+		}}, "certificates.rotateBefore"),
+		table.Entry("ingress class", AppManagerConfig{Ingress: IngressConfig{ClassName: "Nginx"}}, "ingress.className"),
+	)
+})
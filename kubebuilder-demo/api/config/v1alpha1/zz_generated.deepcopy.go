@@ -0,0 +1,138 @@
+//go:build !ignore_autogenerated
+// +build !ignore_autogenerated
+
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Code generated by controller-gen. DO NOT EDIT.
+
+package v1alpha1
+
+import (
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	runtime "k8s.io/apimachinery/pkg/runtime"
+)
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AppManagerConfig) DeepCopyInto(out *AppManagerConfig) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ControllerManagerConfigurationSpec.DeepCopyInto(&out.ControllerManagerConfigurationSpec)
+	if in.Namespaces != nil {
+		in, out := &in.Namespaces, &out.Namespaces
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.NamespaceSelector != nil {
+		in, out := &in.NamespaceSelector, &out.NamespaceSelector
+		*out = new(v1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ProtectedNamespaceSelector != nil {
+		in, out := &in.ProtectedNamespaceSelector, &out.ProtectedNamespaceSelector
+		*out = new(v1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.UnfilteredWatches != nil {
+		in, out := &in.UnfilteredWatches, &out.UnfilteredWatches
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	out.Ingress = in.Ingress
+	if in.Certificates != nil {
+		in, out := &in.Certificates, &out.Certificates
+		*out = new(CertificatesConfig)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AppManagerConfig.
+func (in *AppManagerConfig) DeepCopy() *AppManagerConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(AppManagerConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *AppManagerConfig) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CertificatesConfig) DeepCopyInto(out *CertificatesConfig) {
+	*out = *in
+	if in.Hosts != nil {
+		in, out := &in.Hosts, &out.Hosts
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.MutatingWebhookConfigurations != nil {
+		in, out := &in.MutatingWebhookConfigurations, &out.MutatingWebhookConfigurations
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ValidatingWebhookConfigurations != nil {
+		in, out := &in.ValidatingWebhookConfigurations, &out.ValidatingWebhookConfigurations
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.CustomResourceDefinitions != nil {
+		in, out := &in.CustomResourceDefinitions, &out.CustomResourceDefinitions
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Validity != nil {
+		in, out := &in.Validity, &out.Validity
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.RotateBefore != nil {
+		in, out := &in.RotateBefore, &out.RotateBefore
+		*out = new(v1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CertificatesConfig.
+func (in *CertificatesConfig) DeepCopy() *CertificatesConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(CertificatesConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IngressConfig) DeepCopyInto(out *IngressConfig) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IngressConfig.
+func (in *IngressConfig) DeepCopy() *IngressConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(IngressConfig)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -0,0 +1,580 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	netv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// AppSpec defines the desired state of App
+type AppSpec struct {
+	// Image of the App container.
+	Image string `json:"image"`
+
+	// Replicas of the Deployment. Defaults to 1.
+	// +optional
+	Replicas *int32 `json:"replicas,omitempty"`
+
+	// ImagePullPolicy of the App container. Defaults to Always for images
+	// without a tag or tagged latest, IfNotPresent otherwise.
+	// +kubebuilder:validation:Enum=Always;Never;IfNotPresent
+	// +optional
+	ImagePullPolicy corev1.PullPolicy `json:"imagePullPolicy,omitempty"`
+
+	// Ports exposed by the App container and its Service.
+	// Defaults to a single port named http, 8080 on the Service and 80 in the container.
+	// +optional
+	Ports []AppPort `json:"ports,omitempty"`
+
+	// Service configures the Service in front of the App.
+	// +optional
+	Service AppService `json:"service,omitempty"`
+
+	// Ingress configures the Ingress routing to the App Service.
+	// +optional
+	Ingress AppIngress `json:"ingress,omitempty"`
+
+	// Autoscaling creates a HorizontalPodAutoscaler for the Deployment.
+	// Replicas is ignored while autoscaling is enabled.
+	// +optional
+	Autoscaling *AppAutoscaling `json:"autoscaling,omitempty"`
+
+	// PodDisruptionBudget limits how many Pods of the App can be evicted at
+	// once, e.g. during node drains.
+	// +optional
+	PodDisruptionBudget *AppPodDisruptionBudget `json:"podDisruptionBudget,omitempty"`
+
+	// Placement spreads the Pods of the App across nodes or zones.
+	// +optional
+	Placement AppPlacement `json:"placement,omitempty"`
+
+	// Strategy configures how a new version of the App is rolled out.
+	// +optional
+	Strategy AppStrategy `json:"strategy,omitempty"`
+
+	// Rollback configures how failed rollouts are detected and rolled back.
+	// +optional
+	Rollback AppRollback `json:"rollback,omitempty"`
+
+	// Config lists the ConfigMaps and Secrets used by the App. The Pods are
+	// restarted when the data of the ConfigMaps and Secrets labeled
+	// config.baiding.tech/watch=true changes, the changes of the others are
+	// only picked up by the next rollout.
+	// +optional
+	Config []AppConfigSource `json:"config,omitempty"`
+
+	// Overrides patch the objects generated for the App, e.g. to add a
+	// sidecar or a nodeSelector not supported by the other fields.
+	// +optional
+	Overrides AppOverrides `json:"overrides,omitempty"`
+
+	// Suspend freezes the App, e.g. during an incident: the objects owned by
+	// the App are no longer updated until Suspend is removed.
+	// +optional
+	Suspend *AppSuspend `json:"suspend,omitempty"`
+
+	// DependsOn lists the Apps of the namespace that must be Available before
+	// the Deployment of the App is created or updated.
+	// +optional
+	DependsOn []string `json:"dependsOn,omitempty"`
+}
+
+// AppPort is a port exposed by the App container and its Service
+type AppPort struct {
+	// Name of the port. Defaults to port-<port>.
+	// +optional
+	Name string `json:"name,omitempty"`
+
+	// Port exposed by the Service.
+	Port int32 `json:"port"`
+
+	// TargetPort the container listens on. Defaults to Port.
+	// +optional
+	TargetPort int32 `json:"targetPort,omitempty"`
+
+	// Protocol of the port. Defaults to TCP.
+	// +kubebuilder:validation:Enum=TCP;UDP;SCTP
+	// +optional
+	Protocol corev1.Protocol `json:"protocol,omitempty"`
+}
+
+// AppService configures the Service generated for an App
+type AppService struct {
+	// Enabled creates a Service for the App. It is required when the Ingress is enabled.
+	// +optional
+	Enabled bool `json:"enabled,omitempty"`
+}
+
+// AppIngress configures the Ingress generated for an App
+type AppIngress struct {
+	// Enabled creates an Ingress for the App.
+	// +optional
+	Enabled bool `json:"enabled,omitempty"`
+
+	// Hosts routed to the App. The defaulting webhook sets it to
+	// <name>.<ingress-domain> when empty.
+	// +optional
+	Hosts []string `json:"hosts,omitempty"`
+
+	// Paths routed to the App on every host. Defaults to "/" with pathType Prefix.
+	// +optional
+	Paths []AppIngressPath `json:"paths,omitempty"`
+
+	// IngressClassName of the Ingress. The cluster default class is used when empty.
+	// +optional
+	IngressClassName *string `json:"ingressClassName,omitempty"`
+
+	// Annotations added to the Ingress, e.g. for the ingress controller.
+	// +optional
+	Annotations map[string]string `json:"annotations,omitempty"`
+
+	// TLS enables HTTPS for all hosts of the Ingress.
+	// +optional
+	TLS *AppIngressTLS `json:"tls,omitempty"`
+}
+
+// AppIngressPath is a single HTTP path routed to the App service
+type AppIngressPath struct {
+	Path string `json:"path"`
+
+	// PathType of the path. Defaults to Prefix.
+	// +kubebuilder:validation:Enum=Exact;Prefix;ImplementationSpecific
+	// +optional
+	PathType netv1.PathType `json:"pathType,omitempty"`
+}
+
+// AppIngressTLS configures the certificate used by the Ingress.
+// Either reference an existing secret with SecretName, or let cert-manager
+// issue the certificate by setting Issuer or ClusterIssuer.
+type AppIngressTLS struct {
+	// SecretName of the TLS secret. When an issuer is set, cert-manager stores
+	// the issued certificate in this secret. Defaults to <name>-tls.
+	// +optional
+	SecretName string `json:"secretName,omitempty"`
+
+	// Issuer is the name of a cert-manager Issuer in the namespace of the App.
+	// +optional
+	Issuer string `json:"issuer,omitempty"`
+
+	// ClusterIssuer is the name of a cert-manager ClusterIssuer.
+	// +optional
+	ClusterIssuer string `json:"clusterIssuer,omitempty"`
+}
+
+// AppAutoscaling configures the HorizontalPodAutoscaler generated for an App.
+// The autoscaler targets 80% CPU utilization when no target or metric is set.
+type AppAutoscaling struct {
+	// MinReplicas is the lower limit of replicas. Defaults to 1.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	MinReplicas *int32 `json:"minReplicas,omitempty"`
+
+	// MaxReplicas is the upper limit of replicas.
+	// +kubebuilder:validation:Minimum=1
+	MaxReplicas int32 `json:"maxReplicas"`
+
+	// TargetCPUUtilizationPercentage is the average CPU utilization of the
+	// Pods, relative to their requests, the autoscaler aims for.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	TargetCPUUtilizationPercentage *int32 `json:"targetCPUUtilizationPercentage,omitempty"`
+
+	// TargetMemoryUtilizationPercentage is the average memory utilization of
+	// the Pods, relative to their requests, the autoscaler aims for.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	TargetMemoryUtilizationPercentage *int32 `json:"targetMemoryUtilizationPercentage,omitempty"`
+
+	// Metrics are additional metrics the autoscaler scales on, e.g. Pods or
+	// External metrics served by a custom metrics adapter.
+	// +optional
+	Metrics []autoscalingv2.MetricSpec `json:"metrics,omitempty"`
+}
+
+// AppPodDisruptionBudget configures the PodDisruptionBudget generated for an App.
+// Only one of MinAvailable and MaxUnavailable may be set. MaxUnavailable
+// defaults to 1 when neither is set.
+type AppPodDisruptionBudget struct {
+	// MinAvailable is the number or percentage of Pods that must stay available.
+	// +optional
+	MinAvailable *intstr.IntOrString `json:"minAvailable,omitempty"`
+
+	// MaxUnavailable is the number or percentage of Pods that may be unavailable.
+	// +optional
+	MaxUnavailable *intstr.IntOrString `json:"maxUnavailable,omitempty"`
+}
+
+// TopologySpread is a preset of topology spread constraints.
+// +kubebuilder:validation:Enum=Node;Zone
+type TopologySpread string
+
+const (
+	// TopologySpreadNode spreads the Pods evenly across nodes.
+	TopologySpreadNode TopologySpread = "Node"
+	// TopologySpreadZone spreads the Pods evenly across zones.
+	TopologySpreadZone TopologySpread = "Zone"
+)
+
+// PodAntiAffinity is a preset of pod anti-affinity keeping the Pods of an
+// App on different nodes.
+// +kubebuilder:validation:Enum=Preferred;Required
+type PodAntiAffinity string
+
+const (
+	// PodAntiAffinityPreferred schedules Pods on different nodes when possible.
+	PodAntiAffinityPreferred PodAntiAffinity = "Preferred"
+	// PodAntiAffinityRequired never schedules two Pods on the same node.
+	PodAntiAffinityRequired PodAntiAffinity = "Required"
+)
+
+// AppPlacement configures how the Pods of an App are spread across the cluster
+type AppPlacement struct {
+	// TopologySpread spreads the Pods evenly across nodes or zones.
+	// Pods are still scheduled when the spread cannot be satisfied.
+	// +optional
+	TopologySpread TopologySpread `json:"topologySpread,omitempty"`
+
+	// PodAntiAffinity keeps the Pods on different nodes.
+	// +optional
+	PodAntiAffinity PodAntiAffinity `json:"podAntiAffinity,omitempty"`
+}
+
+// StrategyType is the rollout strategy of an App.
+// +kubebuilder:validation:Enum=RollingUpdate;Canary;BlueGreen
+type StrategyType string
+
+const (
+	// RollingUpdateStrategyType updates the Deployment of the App in place.
+	RollingUpdateStrategyType StrategyType = "RollingUpdate"
+	// CanaryStrategyType runs the new version next to the stable one and
+	// shifts traffic to it step by step.
+	CanaryStrategyType StrategyType = "Canary"
+	// BlueGreenStrategyType starts the new version next to the stable one and
+	// switches the Service to it once it is ready.
+	BlueGreenStrategyType StrategyType = "BlueGreen"
+)
+
+// AppStrategy configures the rollout of an App.
+// A running rollout is promoted or aborted with the
+// rollout.baiding.tech/action annotation set to "promote" or "abort".
+type AppStrategy struct {
+	// Type of the rollout. Defaults to RollingUpdate.
+	// +optional
+	Type StrategyType `json:"type,omitempty"`
+
+	// Canary configures the Canary strategy.
+	// +optional
+	Canary *AppCanaryStrategy `json:"canary,omitempty"`
+
+	// BlueGreen configures the BlueGreen strategy.
+	// +optional
+	BlueGreen *AppBlueGreenStrategy `json:"blueGreen,omitempty"`
+}
+
+// AppCanaryStrategy configures the steps of a canary rollout. The canary
+// runs in a second Deployment named <name>-canary. With the Ingress enabled,
+// the traffic is split by nginx canary annotations, otherwise by the ratio of
+// canary and stable replicas. The Service of an App whose stable Deployment
+// was created without the track label in its selector only selects the
+// stable Pods, its canary only gets traffic through the Ingress.
+type AppCanaryStrategy struct {
+	// Steps of the rollout, with non-decreasing weights. The stable Deployment
+	// is updated after the last step. Defaults to a single step with weight
+	// 20 waiting to be promoted.
+	// +optional
+	Steps []AppCanaryStep `json:"steps,omitempty"`
+}
+
+// AppCanaryStep is a single step of a canary rollout
+type AppCanaryStep struct {
+	// Weight is the percentage of traffic and replicas sent to the canary.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=100
+	Weight int32 `json:"weight"`
+
+	// Pause is how long the rollout stays at this step before moving on,
+	// once the canary is ready. When unset, the rollout waits for the
+	// promote annotation.
+	// +optional
+	Pause *metav1.Duration `json:"pause,omitempty"`
+}
+
+// AppBlueGreenStrategy configures a blue-green rollout. The new version runs
+// in a second Deployment named <name>-preview, reachable through the Service
+// <name>-preview until it is promoted.
+type AppBlueGreenStrategy struct {
+	// AutoPromote switches the Service to the new version as soon as it is
+	// ready. Otherwise the rollout waits for the promote annotation.
+	// +optional
+	AutoPromote bool `json:"autoPromote,omitempty"`
+}
+
+// AppRollback configures the detection of failed rollouts. A rollout fails
+// when the Deployment exceeds its progress deadline, e.g. because the new
+// image keeps crashing.
+type AppRollback struct {
+	// Auto sets the image back to the image of the last healthy revision
+	// when a rollout fails. Failed canary and blue-green rollouts are aborted.
+	// +optional
+	Auto bool `json:"auto,omitempty"`
+
+	// ProgressDeadlineSeconds is how long a rollout may take before it is
+	// considered failed. Defaults to 600.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	ProgressDeadlineSeconds *int32 `json:"progressDeadlineSeconds,omitempty"`
+
+	// HistoryLimit is the number of revisions kept in the status. Defaults to 10.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	HistoryLimit *int32 `json:"historyLimit,omitempty"`
+}
+
+// AppConfigSource references a ConfigMap or a Secret in the namespace of the
+// App. Exactly one of ConfigMap and Secret must be set.
+type AppConfigSource struct {
+	// ConfigMap is the name of a ConfigMap.
+	// +optional
+	ConfigMap string `json:"configMap,omitempty"`
+
+	// Secret is the name of a Secret.
+	// +optional
+	Secret string `json:"secret,omitempty"`
+
+	// MountPath mounts the data as files in the App container. When empty,
+	// the data is exposed as environment variables.
+	// +optional
+	MountPath string `json:"mountPath,omitempty"`
+}
+
+// AppSuspend configures a suspended App
+type AppSuspend struct {
+	// ScaleToZero scales the Deployments of the App to zero replicas while it
+	// is suspended.
+	// +optional
+	ScaleToZero bool `json:"scaleToZero,omitempty"`
+}
+
+// AppOverrides lists the patches applied to the objects generated for an App,
+// per kind. The patches are applied in order.
+type AppOverrides struct {
+	// Deployment patches the Deployments of the App.
+	// +optional
+	Deployment []AppPatch `json:"deployment,omitempty"`
+
+	// Service patches the Services of the App.
+	// +optional
+	Service []AppPatch `json:"service,omitempty"`
+
+	// Ingress patches the Ingresses of the App.
+	// +optional
+	Ingress []AppPatch `json:"ingress,omitempty"`
+}
+
+// PatchType is the type of an AppPatch.
+// +kubebuilder:validation:Enum=StrategicMerge;JSON
+type PatchType string
+
+const (
+	// StrategicMergePatchType patches the object with a strategic merge patch.
+	StrategicMergePatchType PatchType = "StrategicMerge"
+	// JSONPatchType patches the object with a list of JSON patch (RFC 6902) operations.
+	JSONPatchType PatchType = "JSON"
+)
+
+// AppPatch is a patch of an object generated for an App.
+type AppPatch struct {
+	// Type of the patch. Defaults to StrategicMerge.
+	// +optional
+	Type PatchType `json:"type,omitempty"`
+
+	// Patch is the YAML or JSON document of the patch.
+	Patch string `json:"patch"`
+}
+
+// AppStatus defines the observed state of App
+type AppStatus struct {
+	// Conditions describe the current state of the App.
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// PendingCleanupHooks lists the cleanup hooks that have not succeeded yet
+	// while the App is being deleted.
+	// +optional
+	PendingCleanupHooks []string `json:"pendingCleanupHooks,omitempty"`
+
+	// Replicas is the number of Pods currently run by the Deployment of the App.
+	// +optional
+	Replicas int32 `json:"replicas,omitempty"`
+
+	// DesiredReplicas is the number of Pods the App should run, either set by
+	// Replicas or computed by the autoscaler.
+	// +optional
+	DesiredReplicas int32 `json:"desiredReplicas,omitempty"`
+
+	// Rollout reports the progress of the last canary or blue-green rollout.
+	// +optional
+	Rollout *AppRolloutStatus `json:"rollout,omitempty"`
+
+	// History lists the last revisions of the App, the most recent last.
+	// +optional
+	History []AppRevision `json:"history,omitempty"`
+
+	// LastHandledReconcileAt is the value of the reconcile.baiding.tech/requestedAt
+	// annotation of the last reconcile it triggered.
+	// +optional
+	LastHandledReconcileAt string `json:"lastHandledReconcileAt,omitempty"`
+}
+
+// RolloutPhase is the phase of a canary or blue-green rollout.
+type RolloutPhase string
+
+const (
+	// RolloutProgressing means the new version is starting next to the stable one.
+	RolloutProgressing RolloutPhase = "Progressing"
+	// RolloutPaused means the rollout waits for a pause to end or to be promoted.
+	RolloutPaused RolloutPhase = "Paused"
+	// RolloutPromoting means the stable Deployment is updated to the new version.
+	RolloutPromoting RolloutPhase = "Promoting"
+	// RolloutCompleted means the new version replaced the stable one.
+	RolloutCompleted RolloutPhase = "Completed"
+	// RolloutAborted means the new version was removed and the stable one kept.
+	// The same revision is not rolled out again.
+	RolloutAborted RolloutPhase = "Aborted"
+)
+
+// AppRolloutStatus is the progress of a canary or blue-green rollout
+type AppRolloutStatus struct {
+	// Phase of the rollout.
+	Phase RolloutPhase `json:"phase"`
+
+	// StableRevision is the revision of the Pod template of the stable Deployment.
+	// +optional
+	StableRevision string `json:"stableRevision,omitempty"`
+
+	// UpdateRevision is the revision of the Pod template being rolled out.
+	// +optional
+	UpdateRevision string `json:"updateRevision,omitempty"`
+
+	// CurrentStep is the index of the current canary step.
+	// +optional
+	CurrentStep int32 `json:"currentStep,omitempty"`
+
+	// CurrentWeight is the percentage of traffic sent to the canary.
+	// +optional
+	CurrentWeight int32 `json:"currentWeight,omitempty"`
+
+	// StepStartedAt is when the current canary step, or the rollout, started.
+	// +optional
+	StepStartedAt *metav1.Time `json:"stepStartedAt,omitempty"`
+
+	// Message is a human readable description of the rollout state.
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// RevisionPhase is the phase of a revision of an App.
+type RevisionPhase string
+
+const (
+	// RevisionProgressing means the revision is being rolled out.
+	RevisionProgressing RevisionPhase = "Progressing"
+	// RevisionHealthy means all replicas of the revision became available.
+	RevisionHealthy RevisionPhase = "Healthy"
+	// RevisionFailed means the rollout of the revision exceeded its progress deadline.
+	RevisionFailed RevisionPhase = "Failed"
+)
+
+// AppRevision is a revision of the Pod template of an App
+type AppRevision struct {
+	// Revision is the hash of the Pod template.
+	Revision string `json:"revision"`
+
+	// Image of the App container in this revision.
+	Image string `json:"image"`
+
+	// Phase of the revision.
+	Phase RevisionPhase `json:"phase"`
+
+	// DeployedAt is when the revision was last rolled out.
+	DeployedAt metav1.Time `json:"deployedAt"`
+}
+
+// Condition types of an App.
+const (
+	// ConditionCleanup reports the progress of the cleanup hooks run before
+	// a deleted App is removed.
+	ConditionCleanup = "Cleanup"
+
+	// ConditionAutoscaling reports whether the HorizontalPodAutoscaler of an
+	// App with autoscaling enabled is managed, e.g. false when the cluster
+	// does not serve the autoscaling/v2 API.
+	ConditionAutoscaling = "Autoscaling"
+
+	// ConditionIngress reports whether the Ingress of the App can be served,
+	// e.g. whether its IngressClass exists.
+	ConditionIngress = "Ingress"
+
+	// ConditionSuspended reports whether the App is suspended, i.e. whether
+	// its owned objects are left unchanged.
+	ConditionSuspended = "Suspended"
+
+	// ConditionAvailable reports whether all replicas of the stable
+	// Deployment of the App are available.
+	ConditionAvailable = "Available"
+
+	// ConditionWaitingForDependencies reports whether the App waits for the
+	// Apps it depends on to be Available.
+	ConditionWaitingForDependencies = "WaitingForDependencies"
+
+	// ConditionConfig reports whether changes of the ConfigMaps and Secrets
+	// used by the App restart its Pods, i.e. whether they are labeled
+	// config.baiding.tech/watch=true.
+	ConditionConfig = "Config"
+)
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:storageversion
+
+// App is the Schema for the apps API
+type App struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   AppSpec   `json:"spec,omitempty"`
+	Status AppStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// AppList contains a list of App
+type AppList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []App `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&App{}, &AppList{})
+}
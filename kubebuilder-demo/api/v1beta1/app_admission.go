@@ -0,0 +1,85 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// validatingWebhookPath is the path of the validating webhook of Apps, see
+// the webhook marker of ValidateCreate.
+const validatingWebhookPath = "/validate-ingress-baiding-tech-v1beta1-app"
+
+// validatingHandler validates Apps like the handler registered for a
+// webhook.Validator, and also returns the warnings of the validation rules.
+type validatingHandler struct {
+	decoder *admission.Decoder
+}
+
+var _ admission.DecoderInjector = &validatingHandler{}
+
+// InjectDecoder implements admission.DecoderInjector.
+func (h *validatingHandler) InjectDecoder(d *admission.Decoder) error {
+	h.decoder = d
+	return nil
+}
+
+// Handle implements admission.Handler.
+func (h *validatingHandler) Handle(_ context.Context, req admission.Request) admission.Response {
+	app := &App{}
+	var warnings []string
+	var err error
+	switch req.Operation {
+	case admissionv1.Create:
+		if err := h.decoder.Decode(req, app); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+		warnings, err = app.validate("create", nil)
+	case admissionv1.Update:
+		old := &App{}
+		if err := h.decoder.DecodeRaw(req.Object, app); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+		if err := h.decoder.DecodeRaw(req.OldObject, old); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+		warnings, err = app.validate("update", old)
+	case admissionv1.Delete:
+		// 删除时OldObject是被删除的对象
+		if err := h.decoder.DecodeRaw(req.OldObject, app); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+		err = app.ValidateDelete()
+	}
+
+	if err == nil {
+		return admission.Allowed("").WithWarnings(warnings...)
+	}
+	var apiStatus apierrors.APIStatus
+	if errors.As(err, &apiStatus) {
+		status := apiStatus.Status()
+		resp := admission.Response{AdmissionResponse: admissionv1.AdmissionResponse{Allowed: false, Result: &status}}
+		return resp.WithWarnings(warnings...)
+	}
+	return admission.Denied(err.Error()).WithWarnings(warnings...)
+}
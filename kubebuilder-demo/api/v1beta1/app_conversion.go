@@ -0,0 +1,229 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+
+	v1 "github.com/kubebuilder-demo/api/v1"
+)
+
+var _ conversion.Convertible = &App{}
+
+// ConvertTo converts this App to the Hub version (v1).
+func (r *App) ConvertTo(dstRaw conversion.Hub) error {
+	dst := dstRaw.(*v1.App)
+	dst.ObjectMeta = r.ObjectMeta
+
+	dst.Spec.Image = r.Spec.Image
+	dst.Spec.Replicas = r.Spec.Replicas
+	dst.Spec.ImagePullPolicy = r.Spec.ImagePullPolicy
+	dst.Spec.Ports = nil
+	for _, port := range r.Spec.Ports {
+		dst.Spec.Ports = append(dst.Spec.Ports, v1.AppPort(port))
+	}
+	dst.Spec.Service = v1.AppService{Enabled: r.Spec.EnableService}
+	dst.Spec.Ingress = v1.AppIngress{
+		Enabled:          r.Spec.EnableIngress,
+		Hosts:            r.Spec.Ingress.Hosts,
+		IngressClassName: r.Spec.Ingress.IngressClassName,
+		Annotations:      r.Spec.Ingress.Annotations,
+	}
+	for _, path := range r.Spec.Ingress.Paths {
+		dst.Spec.Ingress.Paths = append(dst.Spec.Ingress.Paths, v1.AppIngressPath(path))
+	}
+	if r.Spec.Ingress.TLS != nil {
+		tls := v1.AppIngressTLS(*r.Spec.Ingress.TLS)
+		dst.Spec.Ingress.TLS = &tls
+	}
+
+	dst.Spec.Autoscaling = nil
+	if r.Spec.Autoscaling != nil {
+		autoscaling := v1.AppAutoscaling(*r.Spec.Autoscaling)
+		dst.Spec.Autoscaling = &autoscaling
+	}
+	dst.Spec.PodDisruptionBudget = nil
+	if r.Spec.PodDisruptionBudget != nil {
+		pdb := v1.AppPodDisruptionBudget(*r.Spec.PodDisruptionBudget)
+		dst.Spec.PodDisruptionBudget = &pdb
+	}
+	dst.Spec.Placement = v1.AppPlacement{
+		TopologySpread:  v1.TopologySpread(r.Spec.Placement.TopologySpread),
+		PodAntiAffinity: v1.PodAntiAffinity(r.Spec.Placement.PodAntiAffinity),
+	}
+	dst.Spec.Strategy = v1.AppStrategy{Type: v1.StrategyType(r.Spec.Strategy.Type)}
+	if r.Spec.Strategy.Canary != nil {
+		dst.Spec.Strategy.Canary = &v1.AppCanaryStrategy{}
+		for _, step := range r.Spec.Strategy.Canary.Steps {
+			dst.Spec.Strategy.Canary.Steps = append(dst.Spec.Strategy.Canary.Steps, v1.AppCanaryStep(step))
+		}
+	}
+	if r.Spec.Strategy.BlueGreen != nil {
+		blueGreen := v1.AppBlueGreenStrategy(*r.Spec.Strategy.BlueGreen)
+		dst.Spec.Strategy.BlueGreen = &blueGreen
+	}
+	dst.Spec.Rollback = v1.AppRollback(r.Spec.Rollback)
+	dst.Spec.Config = nil
+	for _, config := range r.Spec.Config {
+		dst.Spec.Config = append(dst.Spec.Config, v1.AppConfigSource(config))
+	}
+	dst.Spec.Overrides = v1.AppOverrides{
+		Deployment: convertPatchesTo(r.Spec.Overrides.Deployment),
+		Service:    convertPatchesTo(r.Spec.Overrides.Service),
+		Ingress:    convertPatchesTo(r.Spec.Overrides.Ingress),
+	}
+	dst.Spec.Suspend = (*v1.AppSuspend)(r.Spec.Suspend)
+	dst.Spec.DependsOn = r.Spec.DependsOn
+
+	dst.Status = v1.AppStatus{
+		Conditions:             r.Status.Conditions,
+		PendingCleanupHooks:    r.Status.PendingCleanupHooks,
+		Replicas:               r.Status.Replicas,
+		DesiredReplicas:        r.Status.DesiredReplicas,
+		LastHandledReconcileAt: r.Status.LastHandledReconcileAt,
+	}
+	if rollout := r.Status.Rollout; rollout != nil {
+		dst.Status.Rollout = &v1.AppRolloutStatus{
+			Phase:          v1.RolloutPhase(rollout.Phase),
+			StableRevision: rollout.StableRevision,
+			UpdateRevision: rollout.UpdateRevision,
+			CurrentStep:    rollout.CurrentStep,
+			CurrentWeight:  rollout.CurrentWeight,
+			StepStartedAt:  rollout.StepStartedAt,
+			Message:        rollout.Message,
+		}
+	}
+	for _, revision := range r.Status.History {
+		dst.Status.History = append(dst.Status.History, v1.AppRevision{
+			Revision:   revision.Revision,
+			Image:      revision.Image,
+			Phase:      v1.RevisionPhase(revision.Phase),
+			DeployedAt: revision.DeployedAt,
+		})
+	}
+	return nil
+}
+
+// ConvertFrom converts from the Hub version (v1) to this version.
+func (r *App) ConvertFrom(srcRaw conversion.Hub) error {
+	src := srcRaw.(*v1.App)
+	r.ObjectMeta = src.ObjectMeta
+
+	r.Spec.Image = src.Spec.Image
+	r.Spec.Replicas = src.Spec.Replicas
+	r.Spec.ImagePullPolicy = src.Spec.ImagePullPolicy
+	r.Spec.Ports = nil
+	for _, port := range src.Spec.Ports {
+		r.Spec.Ports = append(r.Spec.Ports, AppPort(port))
+	}
+	r.Spec.EnableService = src.Spec.Service.Enabled
+	r.Spec.EnableIngress = src.Spec.Ingress.Enabled
+	r.Spec.Ingress = AppIngress{
+		Hosts:            src.Spec.Ingress.Hosts,
+		IngressClassName: src.Spec.Ingress.IngressClassName,
+		Annotations:      src.Spec.Ingress.Annotations,
+	}
+	for _, path := range src.Spec.Ingress.Paths {
+		r.Spec.Ingress.Paths = append(r.Spec.Ingress.Paths, AppIngressPath(path))
+	}
+	if src.Spec.Ingress.TLS != nil {
+		tls := AppIngressTLS(*src.Spec.Ingress.TLS)
+		r.Spec.Ingress.TLS = &tls
+	}
+
+	r.Spec.Autoscaling = nil
+	if src.Spec.Autoscaling != nil {
+		autoscaling := AppAutoscaling(*src.Spec.Autoscaling)
+		r.Spec.Autoscaling = &autoscaling
+	}
+	r.Spec.PodDisruptionBudget = nil
+	if src.Spec.PodDisruptionBudget != nil {
+		pdb := AppPodDisruptionBudget(*src.Spec.PodDisruptionBudget)
+		r.Spec.PodDisruptionBudget = &pdb
+	}
+	r.Spec.Placement = AppPlacement{
+		TopologySpread:  TopologySpread(src.Spec.Placement.TopologySpread),
+		PodAntiAffinity: PodAntiAffinity(src.Spec.Placement.PodAntiAffinity),
+	}
+	r.Spec.Strategy = AppStrategy{Type: StrategyType(src.Spec.Strategy.Type)}
+	if src.Spec.Strategy.Canary != nil {
+		r.Spec.Strategy.Canary = &AppCanaryStrategy{}
+		for _, step := range src.Spec.Strategy.Canary.Steps {
+			r.Spec.Strategy.Canary.Steps = append(r.Spec.Strategy.Canary.Steps, AppCanaryStep(step))
+		}
+	}
+	if src.Spec.Strategy.BlueGreen != nil {
+		blueGreen := AppBlueGreenStrategy(*src.Spec.Strategy.BlueGreen)
+		r.Spec.Strategy.BlueGreen = &blueGreen
+	}
+	r.Spec.Rollback = AppRollback(src.Spec.Rollback)
+	r.Spec.Config = nil
+	for _, config := range src.Spec.Config {
+		r.Spec.Config = append(r.Spec.Config, AppConfigSource(config))
+	}
+	r.Spec.Overrides = AppOverrides{
+		Deployment: convertPatchesFrom(src.Spec.Overrides.Deployment),
+		Service:    convertPatchesFrom(src.Spec.Overrides.Service),
+		Ingress:    convertPatchesFrom(src.Spec.Overrides.Ingress),
+	}
+	r.Spec.Suspend = (*AppSuspend)(src.Spec.Suspend)
+	r.Spec.DependsOn = src.Spec.DependsOn
+
+	r.Status = AppStatus{
+		Conditions:             src.Status.Conditions,
+		PendingCleanupHooks:    src.Status.PendingCleanupHooks,
+		Replicas:               src.Status.Replicas,
+		DesiredReplicas:        src.Status.DesiredReplicas,
+		LastHandledReconcileAt: src.Status.LastHandledReconcileAt,
+	}
+	if rollout := src.Status.Rollout; rollout != nil {
+		r.Status.Rollout = &AppRolloutStatus{
+			Phase:          RolloutPhase(rollout.Phase),
+			StableRevision: rollout.StableRevision,
+			UpdateRevision: rollout.UpdateRevision,
+			CurrentStep:    rollout.CurrentStep,
+			CurrentWeight:  rollout.CurrentWeight,
+			StepStartedAt:  rollout.StepStartedAt,
+			Message:        rollout.Message,
+		}
+	}
+	for _, revision := range src.Status.History {
+		r.Status.History = append(r.Status.History, AppRevision{
+			Revision:   revision.Revision,
+			Image:      revision.Image,
+			Phase:      RevisionPhase(revision.Phase),
+			DeployedAt: revision.DeployedAt,
+		})
+	}
+	return nil
+}
+
+func convertPatchesTo(patches []AppPatch) []v1.AppPatch {
+	var dst []v1.AppPatch
+	for _, patch := range patches {
+		dst = append(dst, v1.AppPatch{Type: v1.PatchType(patch.Type), Patch: patch.Patch})
+	}
+	return dst
+}
+
+func convertPatchesFrom(patches []v1.AppPatch) []AppPatch {
+	var dst []AppPatch
+	for _, patch := range patches {
+		dst = append(dst, AppPatch{Type: PatchType(patch.Type), Patch: patch.Patch})
+	}
+	return dst
+}
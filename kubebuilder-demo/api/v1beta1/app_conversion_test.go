@@ -0,0 +1,92 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	fuzz "github.com/google/gofuzz"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/pointer"
+
+	ingressv1 "github.com/kubebuilder-demo/api/v1"
+)
+
+var _ = Describe("App conversion", func() {
+	const rounds = 1000
+
+	var fuzzer *fuzz.Fuzzer
+	BeforeEach(func() {
+		fuzzer = fuzz.New().NilChance(0.2).NumElements(0, 3)
+	})
+
+	It("converts v1beta1 to v1 and back without losing data", func() {
+		for i := 0; i < rounds; i++ {
+			original := &App{}
+			fuzzer.Fuzz(original)
+			original.TypeMeta = metav1.TypeMeta{}
+
+			hub := &ingressv1.App{}
+			Expect(original.DeepCopy().ConvertTo(hub)).To(Succeed())
+			converted := &App{}
+			Expect(converted.ConvertFrom(hub)).To(Succeed())
+
+			Expect(apiequality.Semantic.DeepEqual(original, converted)).To(BeTrue(),
+				"round trip changed the App:\n%#v\n%#v", original, converted)
+		}
+	})
+
+	It("converts v1 to v1beta1 and back without losing data", func() {
+		for i := 0; i < rounds; i++ {
+			original := &ingressv1.App{}
+			fuzzer.Fuzz(original)
+			original.TypeMeta = metav1.TypeMeta{}
+
+			spoke := &App{}
+			Expect(spoke.ConvertFrom(original.DeepCopy())).To(Succeed())
+			converted := &ingressv1.App{}
+			Expect(spoke.ConvertTo(converted)).To(Succeed())
+
+			Expect(apiequality.Semantic.DeepEqual(original, converted)).To(BeTrue(),
+				"round trip changed the App:\n%#v\n%#v", original, converted)
+		}
+	})
+
+	It("serves v1beta1 Apps as v1 through the conversion webhook", func() {
+		app := &App{
+			ObjectMeta: metav1.ObjectMeta{Name: "conversion", Namespace: "default"},
+			Spec: AppSpec{
+				Image:         "nginx:1.21",
+				Replicas:      pointer.Int32(2),
+				EnableIngress: true,
+				Ingress:       AppIngress{Hosts: []string{"conversion.example.com"}},
+			},
+		}
+		Expect(k8sClient.Create(ctx, app)).To(Succeed())
+
+		converted := &ingressv1.App{}
+		key := types.NamespacedName{Namespace: app.Namespace, Name: app.Name}
+		Expect(k8sClient.Get(ctx, key, converted)).To(Succeed())
+		Expect(converted.Spec.Replicas).To(Equal(pointer.Int32(2)))
+		Expect(converted.Spec.Service.Enabled).To(BeTrue())
+		Expect(converted.Spec.Ingress.Enabled).To(BeTrue())
+		Expect(converted.Spec.Ingress.Hosts).To(Equal([]string{"conversion.example.com"}))
+		Expect(k8sClient.Delete(ctx, converted)).To(Succeed())
+	})
+})
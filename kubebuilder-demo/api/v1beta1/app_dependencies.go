@@ -0,0 +1,99 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"context"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// AppReader reads the objects the validation of an App depends on: the Apps
+// it depends on, to reject dependency cycles, and its Namespace, to protect
+// the Apps of production namespaces. It is set by SetupWebhookWithManager,
+// these checks are skipped when nil.
+var AppReader client.Reader
+
+// validateDependsOn checks the names of the Apps app depends on, and that
+// they do not depend on app in turn.
+func validateDependsOn(app *App, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	names := sets.NewString()
+	for i, name := range app.Spec.DependsOn {
+		idxPath := fldPath.Index(i)
+		for _, msg := range validation.IsDNS1123Subdomain(name) {
+			allErrs = append(allErrs, field.Invalid(idxPath, name, msg))
+		}
+		if name == app.Name {
+			allErrs = append(allErrs, field.Invalid(idxPath, name, "an App must not depend on itself"))
+		}
+		if names.Has(name) {
+			allErrs = append(allErrs, field.Duplicate(idxPath, name))
+		}
+		names.Insert(name)
+	}
+	if len(allErrs) > 0 || AppReader == nil {
+		return allErrs
+	}
+
+	for i, name := range app.Spec.DependsOn {
+		cycle, err := findDependencyCycle(context.Background(), app, name)
+		if err != nil {
+			allErrs = append(allErrs, field.InternalError(fldPath.Index(i), err))
+		} else if cycle != nil {
+			allErrs = append(allErrs, field.Invalid(fldPath.Index(i), name, "creates a dependency cycle: "+strings.Join(cycle, " -> ")))
+		}
+	}
+	return allErrs
+}
+
+// findDependencyCycle follows the dependencies of the App dependency and
+// returns the path leading back to app, or nil if there is none. Missing
+// Apps are ignored, the controller waits for them to be created.
+func findDependencyCycle(ctx context.Context, app *App, dependency string) ([]string, error) {
+	visited := sets.NewString()
+	var visit func(name string, path []string) ([]string, error)
+	visit = func(name string, path []string) ([]string, error) {
+		if name == app.Name {
+			return path, nil
+		}
+		if visited.Has(name) {
+			return nil, nil
+		}
+		visited.Insert(name)
+		dep := &App{}
+		if err := AppReader.Get(ctx, types.NamespacedName{Namespace: app.Namespace, Name: name}, dep); err != nil {
+			if apierrors.IsNotFound(err) {
+				return nil, nil
+			}
+			return nil, err
+		}
+		for _, next := range dep.Spec.DependsOn {
+			if cycle, err := visit(next, append(path, next)); cycle != nil || err != nil {
+				return cycle, err
+			}
+		}
+		return nil, nil
+	}
+	return visit(dependency, []string{app.Name, dependency})
+}
@@ -0,0 +1,168 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+// ApplyPatches applies patches in order to obj, a Deployment, Service or
+// Ingress generated for an App. Fields unknown to obj are rejected.
+func ApplyPatches(obj runtime.Object, patches []AppPatch) error {
+	if len(patches) == 0 {
+		return nil
+	}
+	doc, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	for i, patch := range patches {
+		patchJSON, err := yaml.YAMLToJSON([]byte(patch.Patch))
+		if err != nil {
+			return fmt.Errorf("patch %d: %w", i, err)
+		}
+		switch patch.Type {
+		case JSONPatchType:
+			p, err := jsonpatch.DecodePatch(patchJSON)
+			if err != nil {
+				return fmt.Errorf("patch %d: %w", i, err)
+			}
+			if doc, err = p.Apply(doc); err != nil {
+				return fmt.Errorf("patch %d: %w", i, err)
+			}
+		default:
+			if doc, err = strategicpatch.StrategicMergePatch(doc, patchJSON, obj); err != nil {
+				return fmt.Errorf("patch %d: %w", i, err)
+			}
+		}
+	}
+	// 先清空obj, 否则补丁删除的字段仍然会保留在obj中
+	v := reflect.ValueOf(obj).Elem()
+	v.Set(reflect.Zero(v.Type()))
+	return yaml.UnmarshalStrict(doc, obj)
+}
+
+// validateOverrides checks that the patches of app apply to the objects
+// generated for it, and keep their identity, owner and selector.
+func validateOverrides(app *App, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	overrides := &app.Spec.Overrides
+	for _, patches := range []struct {
+		name    string
+		patches []AppPatch
+	}{{"deployment", overrides.Deployment}, {"service", overrides.Service}, {"ingress", overrides.Ingress}} {
+		for i, patch := range patches.patches {
+			if patch.Type != "" && patch.Type != StrategicMergePatchType && patch.Type != JSONPatchType {
+				allErrs = append(allErrs, field.NotSupported(fldPath.Child(patches.name).Index(i).Child("type"), patch.Type,
+					[]string{string(StrategicMergePatchType), string(JSONPatchType)}))
+			}
+		}
+	}
+	if len(allErrs) > 0 {
+		return allErrs
+	}
+
+	// 补丁应用在controller渲染的对象上, 与controller使用相同的模板
+	if len(overrides.Deployment) > 0 {
+		generated, err := RenderDeployment(app, overrideConfigHash(app))
+		if err != nil {
+			return field.ErrorList{field.InternalError(fldPath.Child("deployment"), err)}
+		}
+		patched := generated.DeepCopy()
+		allErrs = append(allErrs, applyOverrides(patched, overrides.Deployment, fldPath.Child("deployment"))...)
+		if len(allErrs) == 0 {
+			allErrs = append(allErrs, validateOverriddenMeta(generated, patched, fldPath.Child("deployment"))...)
+			if !apiequality.Semantic.DeepEqual(generated.Spec.Selector, patched.Spec.Selector) {
+				allErrs = append(allErrs, field.Forbidden(fldPath.Child("deployment"), "must not change the selector"))
+			} else if selector, err := metav1.LabelSelectorAsSelector(patched.Spec.Selector); err == nil && !selector.Matches(labels.Set(patched.Spec.Template.Labels)) {
+				allErrs = append(allErrs, field.Forbidden(fldPath.Child("deployment"), "the labels of the Pod template must match the selector"))
+			}
+		}
+	}
+	if len(overrides.Service) > 0 {
+		generated, err := RenderService(app)
+		if err != nil {
+			return append(allErrs, field.InternalError(fldPath.Child("service"), err))
+		}
+		patched := generated.DeepCopy()
+		errs := applyOverrides(patched, overrides.Service, fldPath.Child("service"))
+		if len(errs) == 0 {
+			errs = validateOverriddenMeta(generated, patched, fldPath.Child("service"))
+			if !apiequality.Semantic.DeepEqual(generated.Spec.Selector, patched.Spec.Selector) {
+				errs = append(errs, field.Forbidden(fldPath.Child("service"), "must not change the selector"))
+			}
+		}
+		allErrs = append(allErrs, errs...)
+	}
+	if len(overrides.Ingress) > 0 {
+		generated, err := RenderIngress(app)
+		if err != nil {
+			return append(allErrs, field.InternalError(fldPath.Child("ingress"), err))
+		}
+		patched := generated.DeepCopy()
+		errs := applyOverrides(patched, overrides.Ingress, fldPath.Child("ingress"))
+		if len(errs) == 0 {
+			errs = validateOverriddenMeta(generated, patched, fldPath.Child("ingress"))
+		}
+		allErrs = append(allErrs, errs...)
+	}
+	return allErrs
+}
+
+// applyOverrides applies patches one by one to obj, reporting the first patch that does not apply.
+func applyOverrides(obj runtime.Object, patches []AppPatch, fldPath *field.Path) field.ErrorList {
+	for i, patch := range patches {
+		if err := ApplyPatches(obj, []AppPatch{patch}); err != nil {
+			return field.ErrorList{field.Invalid(fldPath.Index(i).Child("patch"), patch.Patch, err.Error())}
+		}
+	}
+	return nil
+}
+
+// validateOverriddenMeta checks that the patches kept the name, namespace and owners of generated.
+func validateOverriddenMeta(generated, patched client.Object, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	if generated.GetName() != patched.GetName() || generated.GetNamespace() != patched.GetNamespace() {
+		allErrs = append(allErrs, field.Forbidden(fldPath, "must not change the name or the namespace"))
+	}
+	if !apiequality.Semantic.DeepEqual(generated.GetOwnerReferences(), patched.GetOwnerReferences()) {
+		allErrs = append(allErrs, field.Forbidden(fldPath, "must not change the owner references"))
+	}
+	return allErrs
+}
+
+// overrideConfigHash returns a placeholder for the hash of the ConfigMaps and
+// Secrets of app, so the Deployment is validated with the annotation the
+// controller sets when app uses any.
+func overrideConfigHash(app *App) string {
+	if len(app.Spec.Config) == 0 {
+		return ""
+	}
+	return "webhook"
+}
@@ -0,0 +1,64 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// ProtectedLabel protects an App from deletion when set to "true".
+	ProtectedLabel = "ingress.baiding.tech/protected"
+
+	// ConfirmDeleteAnnotation confirms the deletion of a protected App when
+	// set to the name of the App.
+	ConfirmDeleteAnnotation = "ingress.baiding.tech/confirm-delete"
+)
+
+// ProtectedNamespaceSelector selects the production namespaces, whose Apps
+// are protected from deletion. It is set by the manager from the
+// protectedNamespaceSelector of its configuration.
+var ProtectedNamespaceSelector = labels.SelectorFromSet(labels.Set{"environment": "production"})
+
+// deletionProtection returns why r is protected from deletion, or an empty
+// string if it is not.
+func (r *App) deletionProtection(ctx context.Context) (string, error) {
+	if r.Labels[ProtectedLabel] == "true" {
+		return fmt.Sprintf("the App is protected by the label %s=true", ProtectedLabel), nil
+	}
+	if AppReader == nil || ProtectedNamespaceSelector == nil {
+		return "", nil
+	}
+	ns := &corev1.Namespace{}
+	if err := AppReader.Get(ctx, types.NamespacedName{Name: r.Namespace}, ns); err != nil {
+		return "", client.IgnoreNotFound(err)
+	}
+	// namespace被删除时, 其中的App也必须能被删除
+	if !ns.DeletionTimestamp.IsZero() {
+		return "", nil
+	}
+	if ProtectedNamespaceSelector.Matches(labels.Set(ns.Labels)) {
+		return fmt.Sprintf("the namespace %s is a production namespace", r.Namespace), nil
+	}
+	return "", nil
+}
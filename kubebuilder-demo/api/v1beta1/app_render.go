@@ -0,0 +1,239 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"text/template"
+
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	netv1 "k8s.io/api/networking/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// ConfigHashAnnotation is set on the Pod template to the hash of the data of
+// the ConfigMaps and Secrets used by an App, so changes restart the Pods.
+const ConfigHashAnnotation = "config.baiding.tech/hash"
+
+// Pod的track label区分稳定版本和发布中的新版本
+const (
+	TrackLabel   = "track"
+	TrackStable  = "stable"
+	TrackCanary  = "canary"
+	TrackPreview = "preview"
+)
+
+//go:embed templates/*.yml
+var templateFS embed.FS
+
+// templates are the templates of the objects generated for an App. They are
+// rendered by the controller, and by the webhook to validate the overrides.
+var templates = template.Must(template.New("").Funcs(template.FuncMap{
+	"ingressHosts":       ingressHosts,
+	"ingressPaths":       ingressPaths,
+	"ingressAnnotations": ingressAnnotations,
+	"ingressTLSSecret":   ingressTLSSecret,
+	"toJson":             toJson,
+	"topologyKey":        topologyKey,
+	"serviceTrack":       serviceTrack,
+	"configEnv":          configEnv,
+	"configMounts":       configMounts,
+}).ParseFS(templateFS, "templates/*.yml"))
+
+// render renders the template name for app into obj.
+func render(name string, app *App, obj interface{}) error {
+	// 使用设置了默认值的副本渲染, 兼容webhook启用之前创建的App
+	app = app.DeepCopy()
+	SetDefaults(app)
+	b := new(bytes.Buffer)
+	if err := templates.ExecuteTemplate(b, name+".yml", app); err != nil {
+		return err
+	}
+	if err := yaml.Unmarshal(b.Bytes(), obj); err != nil {
+		return fmt.Errorf("unable to decode the rendered %s template: %w", name, err)
+	}
+	return nil
+}
+
+// RenderDeployment returns the Deployment of app. configHash is the hash of
+// the ConfigMaps and Secrets used by app, it is not set when empty.
+func RenderDeployment(app *App, configHash string) (*appsv1.Deployment, error) {
+	d := &appsv1.Deployment{}
+	if err := render("deployment", app, d); err != nil {
+		return nil, err
+	}
+	if configHash != "" {
+		metav1.SetMetaDataAnnotation(&d.Spec.Template.ObjectMeta, ConfigHashAnnotation, configHash)
+	}
+	return d, nil
+}
+
+// RenderService returns the Service of app.
+func RenderService(app *App) (*corev1.Service, error) {
+	s := &corev1.Service{}
+	if err := render("service", app, s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// RenderIngress returns the Ingress of app.
+func RenderIngress(app *App) (*netv1.Ingress, error) {
+	i := &netv1.Ingress{}
+	if err := render("ingress", app, i); err != nil {
+		return nil, err
+	}
+	return i, nil
+}
+
+// RenderHorizontalPodAutoscaler returns the HPA of app with autoscaling enabled.
+func RenderHorizontalPodAutoscaler(app *App) (*autoscalingv2.HorizontalPodAutoscaler, error) {
+	h := &autoscalingv2.HorizontalPodAutoscaler{}
+	if err := render("hpa", app, h); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// RenderPodDisruptionBudget returns the PodDisruptionBudget of app.
+func RenderPodDisruptionBudget(app *App) (*policyv1.PodDisruptionBudget, error) {
+	p := &policyv1.PodDisruptionBudget{}
+	if err := render("pdb", app, p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// toJson 将对象渲染为json, json同时也是合法的yaml
+func toJson(v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	return string(b), err
+}
+
+// ingressHosts 返回Ingress的host, 没有配置时使用默认的<name>.<domain>
+func ingressHosts(app *App) []string {
+	if len(app.Spec.Ingress.Hosts) > 0 {
+		return app.Spec.Ingress.Hosts
+	}
+	return []string{DefaultIngressHost(app.Name)}
+}
+
+// ingressPaths 返回Ingress的path, 没有配置时使用"/"
+func ingressPaths(app *App) []AppIngressPath {
+	if len(app.Spec.Ingress.Paths) == 0 {
+		return []AppIngressPath{{Path: "/", PathType: netv1.PathTypePrefix}}
+	}
+	paths := make([]AppIngressPath, 0, len(app.Spec.Ingress.Paths))
+	for _, p := range app.Spec.Ingress.Paths {
+		if p.PathType == "" {
+			p.PathType = netv1.PathTypePrefix
+		}
+		paths = append(paths, p)
+	}
+	return paths
+}
+
+// ingressAnnotations 返回用户配置的annotations, 配置了cert-manager的issuer时加上对应的annotation
+func ingressAnnotations(app *App) map[string]string {
+	annotations := map[string]string{}
+	for k, v := range app.Spec.Ingress.Annotations {
+		annotations[k] = v
+	}
+	if tls := app.Spec.Ingress.TLS; tls != nil {
+		if tls.Issuer != "" {
+			annotations["cert-manager.io/issuer"] = tls.Issuer
+		}
+		if tls.ClusterIssuer != "" {
+			annotations["cert-manager.io/cluster-issuer"] = tls.ClusterIssuer
+		}
+	}
+	return annotations
+}
+
+// ingressTLSSecret 返回存放证书的secret名称, 默认为<name>-tls
+func ingressTLSSecret(app *App) string {
+	if tls := app.Spec.Ingress.TLS; tls != nil && tls.SecretName != "" {
+		return tls.SecretName
+	}
+	return app.Name + "-tls"
+}
+
+// topologyKey 返回topology spread预设对应的节点label
+func topologyKey(spread TopologySpread) string {
+	if spread == TopologySpreadZone {
+		return corev1.LabelTopologyZone
+	}
+	return corev1.LabelHostname
+}
+
+// serviceTrack 返回Service需要选择的track, 为空时选择App的所有Pod
+func serviceTrack(app *App) string {
+	rollout := app.Status.Rollout
+	if !RolloutInProgress(rollout) {
+		return ""
+	}
+	switch app.Spec.Strategy.Type {
+	case BlueGreenStrategyType:
+		// 蓝绿发布在promote之后才切换到新版本
+		if rollout.Phase == RolloutPromoting {
+			return TrackPreview
+		}
+		return TrackStable
+	case CanaryStrategyType:
+		// 启用了ingress时按照ingress的权重分配流量, 否则按照副本数分配
+		if app.Spec.EnableIngress {
+			return TrackStable
+		}
+	}
+	return ""
+}
+
+// configMount 是挂载为文件的ConfigMap或Secret
+// +kubebuilder:object:generate=false
+type configMount struct {
+	AppConfigSource
+	Name string
+}
+
+// configEnv 返回作为环境变量使用的ConfigMap和Secret
+func configEnv(app *App) []AppConfigSource {
+	var sources []AppConfigSource
+	for _, source := range app.Spec.Config {
+		if source.MountPath == "" {
+			sources = append(sources, source)
+		}
+	}
+	return sources
+}
+
+// configMounts 返回挂载为文件的ConfigMap和Secret, volume名称为config-<index>
+func configMounts(app *App) []configMount {
+	var mounts []configMount
+	for i, source := range app.Spec.Config {
+		if source.MountPath != "" {
+			mounts = append(mounts, configMount{AppConfigSource: source, Name: fmt.Sprintf("config-%d", i)})
+		}
+	}
+	return mounts
+}
@@ -0,0 +1,221 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// Severity tells how a violation of a ValidationRule is reported.
+type Severity string
+
+const (
+	// SeverityError rejects the App.
+	SeverityError Severity = "Error"
+	// SeverityWarning admits the App and returns the violation to the client
+	// as an admission warning, e.g. for the usage of deprecated fields.
+	SeverityWarning Severity = "Warning"
+)
+
+// DefaultingRule fills in unset fields of an App.
+// +kubebuilder:object:generate=false
+type DefaultingRule struct {
+	// Name identifies the rule.
+	Name string
+	// Path is the path of the fields set by the rule.
+	Path *field.Path
+	// Default sets the unset fields of app. Applying it twice must not
+	// change app.
+	Default func(app *App)
+}
+
+// ValidationRule checks fields of an App.
+// +kubebuilder:object:generate=false
+type ValidationRule struct {
+	// Name identifies the rule.
+	Name string
+	// Path is the path of the fields checked by the rule, passed to Validate.
+	Path *field.Path
+	// Severity of the violations returned by Validate.
+	Severity Severity
+	// Validate checks app. old is the App replaced by an update, nil on create.
+	Validate func(app, old *App, fldPath *field.Path) field.ErrorList
+}
+
+var (
+	defaultingRules []DefaultingRule
+	validationRules []ValidationRule
+)
+
+// RegisterDefaultingRule adds rule to the rules run by Default, after the
+// rules already registered. It panics if the name of rule is already used.
+func RegisterDefaultingRule(rule DefaultingRule) {
+	for _, r := range defaultingRules {
+		if r.Name == rule.Name {
+			panic(fmt.Sprintf("defaulting rule %s is already registered", rule.Name))
+		}
+	}
+	defaultingRules = append(defaultingRules, rule)
+}
+
+// RegisterValidationRule adds rule to the rules run by ValidateCreate and
+// ValidateUpdate. It panics if the name of rule is already used.
+func RegisterValidationRule(rule ValidationRule) {
+	for _, r := range validationRules {
+		if r.Name == rule.Name {
+			panic(fmt.Sprintf("validation rule %s is already registered", rule.Name))
+		}
+	}
+	if rule.Severity == "" {
+		rule.Severity = SeverityError
+	}
+	validationRules = append(validationRules, rule)
+}
+
+// runValidationRules runs the validation rules on app, old being the App
+// replaced by an update. It returns the violations of the rules with
+// SeverityError, and the violations of the other rules as warnings.
+func runValidationRules(app, old *App) (field.ErrorList, []string) {
+	var allErrs field.ErrorList
+	var warnings []string
+	for _, rule := range validationRules {
+		errs := rule.Validate(app, old, rule.Path)
+		if rule.Severity == SeverityWarning {
+			for _, err := range errs {
+				warnings = append(warnings, err.Error())
+			}
+			continue
+		}
+		allErrs = append(allErrs, errs...)
+	}
+	return allErrs, warnings
+}
+
+// ingressClassAnnotation is the annotation selecting the IngressClass before
+// the ingressClassName field of Ingresses.
+const ingressClassAnnotation = "kubernetes.io/ingress.class"
+
+func init() {
+	specPath := field.NewPath("spec")
+
+	// 规则按照注册的顺序执行, 例如enable-service要在ports之前设置
+	for _, rule := range []DefaultingRule{
+		{Name: "replicas", Path: specPath.Child("replicas"), Default: defaultReplicas},
+		{Name: "enable-service", Path: specPath.Child("enable-service"), Default: defaultEnableService},
+		{Name: "image-pull-policy", Path: specPath.Child("imagePullPolicy"), Default: defaultImagePullPolicy},
+		{Name: "ports", Path: specPath.Child("ports"), Default: defaultPorts},
+		{Name: "ingress", Path: specPath.Child("ingress"), Default: defaultIngress},
+		{Name: "autoscaling", Path: specPath.Child("autoscaling"), Default: defaultAutoscaling},
+		{Name: "pod-disruption-budget", Path: specPath.Child("podDisruptionBudget"), Default: defaultPodDisruptionBudget},
+		{Name: "strategy", Path: specPath.Child("strategy"), Default: defaultStrategy},
+		{Name: "overrides", Path: specPath.Child("overrides"), Default: defaultOverrides},
+		{Name: "rollback", Path: specPath.Child("rollback"), Default: defaultRollback},
+	} {
+		RegisterDefaultingRule(rule)
+	}
+
+	for _, rule := range []ValidationRule{
+		{Name: "enable-service", Path: specPath.Child("enable-service"), Validate: func(app, _ *App, fldPath *field.Path) field.ErrorList {
+			return validateEnableService(app, fldPath)
+		}},
+		{Name: "service-name", Path: field.NewPath("metadata", "name"), Validate: func(app, _ *App, fldPath *field.Path) field.ErrorList {
+			return validateServiceName(app, fldPath)
+		}},
+		{Name: "image", Path: specPath.Child("image"), Validate: func(app, _ *App, fldPath *field.Path) field.ErrorList {
+			return validateImage(app.Spec.Image, fldPath)
+		}},
+		{Name: "replicas", Path: specPath.Child("replicas"), Validate: func(app, _ *App, fldPath *field.Path) field.ErrorList {
+			return validateReplicas(app.Spec.Replicas, fldPath)
+		}},
+		{Name: "ports", Path: specPath.Child("ports"), Validate: func(app, _ *App, fldPath *field.Path) field.ErrorList {
+			return validatePorts(app.Spec.Ports, fldPath)
+		}},
+		{Name: "ingress", Path: specPath.Child("ingress"), Validate: func(app, _ *App, fldPath *field.Path) field.ErrorList {
+			if !app.Spec.EnableIngress {
+				return nil
+			}
+			return validateIngress(&app.Spec.Ingress, fldPath)
+		}},
+		{Name: "autoscaling", Path: specPath.Child("autoscaling"), Validate: func(app, _ *App, fldPath *field.Path) field.ErrorList {
+			if app.Spec.Autoscaling == nil {
+				return nil
+			}
+			return validateAutoscaling(app.Spec.Autoscaling, fldPath)
+		}},
+		{Name: "pod-disruption-budget", Path: specPath.Child("podDisruptionBudget"), Validate: func(app, _ *App, fldPath *field.Path) field.ErrorList {
+			if app.Spec.PodDisruptionBudget == nil {
+				return nil
+			}
+			return validatePodDisruptionBudget(app.Spec.PodDisruptionBudget, fldPath)
+		}},
+		{Name: "strategy", Path: specPath.Child("strategy"), Validate: func(app, _ *App, fldPath *field.Path) field.ErrorList {
+			return validateStrategy(&app.Spec.Strategy, fldPath)
+		}},
+		{Name: "rollback", Path: specPath.Child("rollback"), Validate: func(app, _ *App, fldPath *field.Path) field.ErrorList {
+			return validateRollback(&app.Spec.Rollback, fldPath)
+		}},
+		{Name: "config", Path: specPath.Child("config"), Validate: func(app, _ *App, fldPath *field.Path) field.ErrorList {
+			return validateConfig(app.Spec.Config, fldPath)
+		}},
+		{Name: "overrides", Path: specPath.Child("overrides"), Validate: func(app, _ *App, fldPath *field.Path) field.ErrorList {
+			return validateOverrides(app, fldPath)
+		}},
+		{Name: "depends-on", Path: specPath.Child("dependsOn"), Validate: func(app, _ *App, fldPath *field.Path) field.ErrorList {
+			return validateDependsOn(app, fldPath)
+		}},
+		// 发布中的track Deployment和Service的selector取决于发布策略, 发布结束之前不允许修改
+		{Name: "strategy-type-during-rollout", Path: specPath.Child("strategy", "type"), Validate: validateStrategyTypeUpdate},
+		{Name: "deprecated-ingress-class-annotation", Path: specPath.Child("ingress", "annotations"), Severity: SeverityWarning, Validate: validateIngressClassAnnotation},
+		{Name: "unpinned-image", Path: specPath.Child("image"), Severity: SeverityWarning, Validate: validateImagePinned},
+	} {
+		RegisterValidationRule(rule)
+	}
+}
+
+// validateStrategyTypeUpdate forbids changing the strategy type of an App
+// while a rollout is in progress: the canary or preview Deployment and the
+// track selected by the Service depend on it.
+func validateStrategyTypeUpdate(app, old *App, fldPath *field.Path) field.ErrorList {
+	if old == nil || !RolloutInProgress(old.Status.Rollout) || app.Spec.Strategy.Type == old.Spec.Strategy.Type {
+		return nil
+	}
+	return field.ErrorList{field.Forbidden(fldPath, fmt.Sprintf("must not change while the %s rollout is in progress, promote or abort it first", old.Spec.Strategy.Type))}
+}
+
+// validateIngressClassAnnotation reports the deprecated IngressClass
+// annotation, replaced by spec.ingress.ingressClassName.
+func validateIngressClassAnnotation(app, _ *App, fldPath *field.Path) field.ErrorList {
+	class, ok := app.Spec.Ingress.Annotations[ingressClassAnnotation]
+	if !ok {
+		return nil
+	}
+	return field.ErrorList{field.Invalid(fldPath.Key(ingressClassAnnotation), class,
+		"the annotation is deprecated, use spec.ingress.ingressClassName instead")}
+}
+
+// validateImagePinned reports images without a tag or tagged latest, whose
+// version changes when the Pods are recreated.
+func validateImagePinned(app, _ *App, fldPath *field.Path) field.ErrorList {
+	image := app.Spec.Image
+	if image == "" || defaultPullPolicy(image) != corev1.PullAlways {
+		return nil
+	}
+	return field.ErrorList{field.Invalid(fldPath, image, "the image is not pinned to a version, use a versioned tag or a digest")}
+}
@@ -0,0 +1,138 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"sync"
+
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// warningRecorder collects the warnings returned by the API server.
+type warningRecorder struct {
+	mu       sync.Mutex
+	warnings []string
+}
+
+func (r *warningRecorder) HandleWarningHeader(_ int, _ string, text string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.warnings = append(r.warnings, text)
+}
+
+var _ = Describe("App rules", func() {
+	validationRule := func(name string) ValidationRule {
+		for _, rule := range validationRules {
+			if rule.Name == name {
+				return rule
+			}
+		}
+		Fail("no validation rule named " + name)
+		return ValidationRule{}
+	}
+
+	newApp := func() *App {
+		app := &App{
+			ObjectMeta: metav1.ObjectMeta{Name: "rules", Namespace: "default"},
+			Spec:       AppSpec{Image: "nginx:1.21", EnableIngress: true},
+		}
+		app.Default()
+		return app
+	}
+
+	It("registers every rule with a path and a severity", func() {
+		for _, rule := range defaultingRules {
+			Expect(rule.Path).NotTo(BeNil(), "defaulting rule %s", rule.Name)
+			Expect(rule.Default).NotTo(BeNil(), "defaulting rule %s", rule.Name)
+		}
+		for _, rule := range validationRules {
+			Expect(rule.Path).NotTo(BeNil(), "validation rule %s", rule.Name)
+			Expect(rule.Severity).To(BeElementOf(SeverityError, SeverityWarning), "validation rule %s", rule.Name)
+		}
+	})
+
+	It("rejects rules registered twice", func() {
+		Expect(func() { RegisterDefaultingRule(defaultingRules[0]) }).To(Panic())
+		Expect(func() { RegisterValidationRule(validationRules[0]) }).To(Panic())
+	})
+
+	table.DescribeTable("reports the violations of a rule at its path",
+		func(name string, mutate func(app, old *App), fields ...string) {
+			rule := validationRule(name)
+			app, old := newApp(), newApp()
+			mutate(app, old)
+			var got []string
+			for _, err := range rule.Validate(app, old, rule.Path) {
+				got = append(got, err.Field)
+			}
+			Expect(got).To(ConsistOf(fields))
+		},
+		table.Entry("valid image", "image", func(app, _ *App) {}),
+		table.Entry("invalid image", "image", func(app, _ *App) { app.Spec.Image = "Nginx" }, "spec.image"),
+		table.Entry("strategy changed during a rollout", "strategy-type-during-rollout", func(app, old *App) {
+			old.Status.Rollout = &AppRolloutStatus{Phase: RolloutPaused}
+			app.Spec.Strategy.Type = CanaryStrategyType
+		}, "spec.strategy.type"),
+		table.Entry("strategy changed after a rollout", "strategy-type-during-rollout", func(app, old *App) {
+			old.Status.Rollout = &AppRolloutStatus{Phase: RolloutCompleted}
+			app.Spec.Strategy.Type = CanaryStrategyType
+		}),
+		table.Entry("pinned image", "unpinned-image", func(app, _ *App) {}),
+		table.Entry("latest image", "unpinned-image", func(app, _ *App) { app.Spec.Image = "nginx:latest" }, "spec.image"),
+		table.Entry("IngressClass annotation", "deprecated-ingress-class-annotation", func(app, _ *App) {
+			app.Spec.Ingress.Annotations = map[string]string{ingressClassAnnotation: "nginx"}
+		}, "spec.ingress.annotations[kubernetes.io/ingress.class]"),
+	)
+
+	It("skips the strategy type rule on create", func() {
+		app := newApp()
+		app.Status.Rollout = &AppRolloutStatus{Phase: RolloutPaused}
+		rule := validationRule("strategy-type-during-rollout")
+		Expect(rule.Validate(app, nil, rule.Path)).To(BeEmpty())
+	})
+
+	It("returns warnings without rejecting the App", func() {
+		app := newApp()
+		app.Spec.Image = "nginx"
+		app.Spec.Ingress.Annotations = map[string]string{ingressClassAnnotation: "nginx"}
+		allErrs, warnings := runValidationRules(app, nil)
+		Expect(allErrs).To(BeEmpty())
+		Expect(warnings).To(ConsistOf(
+			ContainSubstring("spec.image"),
+			ContainSubstring("spec.ingress.annotations[kubernetes.io/ingress.class]"),
+		))
+	})
+
+	It("returns the warnings to the client through the API server", func() {
+		recorder := &warningRecorder{}
+		config := rest.CopyConfig(cfg)
+		config.WarningHandler = recorder
+		c, err := client.New(config, client.Options{Scheme: k8sClient.Scheme()})
+		Expect(err).NotTo(HaveOccurred())
+
+		app := newApp()
+		app.Name = "warnings"
+		app.Spec.Image = "nginx:latest"
+		Expect(c.Create(ctx, app)).To(Succeed())
+		Expect(recorder.warnings).To(ContainElement(And(ContainSubstring("spec.image"), ContainSubstring("not pinned"))))
+	})
+})
@@ -17,18 +17,59 @@ limitations under the License.
 package v1beta1
 
 import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	apivalidation "k8s.io/apimachinery/pkg/api/validation"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/utils/pointer"
 	ctrl "sigs.k8s.io/controller-runtime"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
+
+	"github.com/kubebuilder-demo/metrics"
 )
 
 // log is for logging in this package.
 var applog = logf.Log.WithName("app-resource")
 
+// MaxReplicas is the largest number of replicas an App may request.
+const MaxReplicas = 1000
+
+// DefaultTargetCPUUtilizationPercentage is the CPU utilization target of an
+// autoscaled App that does not configure any metric.
+const DefaultTargetCPUUtilizationPercentage = 80
+
+// DefaultCanaryWeight is the weight of the single step of a canary rollout
+// that does not configure any step.
+const DefaultCanaryWeight = 20
+
+// DefaultHistoryLimit is the number of revisions kept in the status of an App.
+const DefaultHistoryLimit = 10
+
+// IngressDomain is the domain used to build the default Ingress host
+// <name>.<domain> of an App. It is set by the manager from --ingress-domain.
+var IngressDomain = "baiding.tech"
+
+// IngressClassName is the IngressClass of the Apps that do not set one. The
+// cluster default class is used when empty. It is set by the manager from
+// --ingress-class.
+var IngressClassName = ""
+
 func (r *App) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	// 直接读取API server, 避免刚创建的App还没有同步到cache
+	AppReader = mgr.GetAPIReader()
+	// webhook.Validator不能返回警告, 先注册自己的validating handler, builder不会重复注册这个路径
+	mgr.GetWebhookServer().Register(validatingWebhookPath, &webhook.Admission{Handler: &validatingHandler{}})
 	return ctrl.NewWebhookManagedBy(mgr).
 		For(r).
 		Complete()
@@ -44,39 +85,432 @@ var _ webhook.Defaulter = &App{}
 func (r *App) Default() {
 	applog.Info("default", "name", r.Name)
 
-	// TODO(user): fill in your defaulting logic.
-	// 需求设置为反的. 如果enableIngress 为 false 那么就变为true
-	// 如果enableIngress为true 那么就变为false
-	r.Spec.EnableIngress = !r.Spec.EnableIngress
+	SetDefaults(r)
+}
+
+// SetDefaults fills in the unset fields of app by running the registered
+// defaulting rules in order. Rules only set empty fields, so applying it
+// again to an already defaulted App does not change it.
+func SetDefaults(app *App) {
+	for _, rule := range defaultingRules {
+		rule.Default(app)
+	}
+}
+
+func defaultReplicas(app *App) {
+	if app.Spec.Replicas == nil {
+		app.Spec.Replicas = pointer.Int32(1)
+	}
+}
+
+func defaultEnableService(app *App) {
+	// 启用了ingress时, service也必须启用
+	if app.Spec.EnableIngress {
+		app.Spec.EnableService = true
+	}
+}
+
+func defaultImagePullPolicy(app *App) {
+	if app.Spec.ImagePullPolicy == "" {
+		app.Spec.ImagePullPolicy = defaultPullPolicy(app.Spec.Image)
+	}
+}
+
+func defaultPorts(app *App) {
+	spec := &app.Spec
+	if len(spec.Ports) == 0 {
+		spec.Ports = []AppPort{{Name: "http", Port: 8080, TargetPort: 80}}
+	}
+	for i := range spec.Ports {
+		port := &spec.Ports[i]
+		if port.Name == "" {
+			port.Name = fmt.Sprintf("port-%d", port.Port)
+		}
+		if port.TargetPort == 0 {
+			port.TargetPort = port.Port
+		}
+		if port.Protocol == "" {
+			port.Protocol = corev1.ProtocolTCP
+		}
+	}
+}
+
+func defaultIngress(app *App) {
+	spec := &app.Spec
+	if !spec.EnableIngress {
+		return
+	}
+	// 没有配置host时, 使用<name>.<domain>作为默认host
+	if len(spec.Ingress.Hosts) == 0 {
+		spec.Ingress.Hosts = []string{DefaultIngressHost(app.Name)}
+	}
+	if spec.Ingress.IngressClassName == nil && IngressClassName != "" {
+		spec.Ingress.IngressClassName = pointer.String(IngressClassName)
+	}
+}
+
+func defaultAutoscaling(app *App) {
+	as := app.Spec.Autoscaling
+	if as == nil {
+		return
+	}
+	if as.MinReplicas == nil {
+		as.MinReplicas = pointer.Int32(1)
+	}
+	// 没有配置任何指标时, 按照CPU使用率扩缩容
+	if as.TargetCPUUtilizationPercentage == nil && as.TargetMemoryUtilizationPercentage == nil && len(as.Metrics) == 0 {
+		as.TargetCPUUtilizationPercentage = pointer.Int32(DefaultTargetCPUUtilizationPercentage)
+	}
+}
+
+func defaultPodDisruptionBudget(app *App) {
+	// 默认最多允许一个Pod不可用
+	if pdb := app.Spec.PodDisruptionBudget; pdb != nil && pdb.MinAvailable == nil && pdb.MaxUnavailable == nil {
+		maxUnavailable := intstr.FromInt(1)
+		pdb.MaxUnavailable = &maxUnavailable
+	}
+}
+
+func defaultStrategy(app *App) {
+	strategy := &app.Spec.Strategy
+	if strategy.Type == "" {
+		strategy.Type = RollingUpdateStrategyType
+	}
+	// 金丝雀发布默认只有一步, 20%的流量, 等待手动promote
+	if strategy.Type == CanaryStrategyType {
+		if strategy.Canary == nil {
+			strategy.Canary = &AppCanaryStrategy{}
+		}
+		if len(strategy.Canary.Steps) == 0 {
+			strategy.Canary.Steps = []AppCanaryStep{{Weight: DefaultCanaryWeight}}
+		}
+	}
+}
+
+func defaultOverrides(app *App) {
+	overrides := &app.Spec.Overrides
+	for _, patches := range [][]AppPatch{overrides.Deployment, overrides.Service, overrides.Ingress} {
+		for i := range patches {
+			if patches[i].Type == "" {
+				patches[i].Type = StrategicMergePatchType
+			}
+		}
+	}
+}
+
+func defaultRollback(app *App) {
+	if app.Spec.Rollback.HistoryLimit == nil {
+		app.Spec.Rollback.HistoryLimit = pointer.Int32(DefaultHistoryLimit)
+	}
+}
+
+// defaultPullPolicy follows the defaulting of Pods: images without a tag
+// or tagged latest are always pulled.
+func defaultPullPolicy(image string) corev1.PullPolicy {
+	if strings.Contains(image, "@") {
+		return corev1.PullIfNotPresent
+	}
+	tag := ""
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		tag = image[i+1:]
+	}
+	if tag == "" || tag == "latest" {
+		return corev1.PullAlways
+	}
+	return corev1.PullIfNotPresent
 }
 
-// TODO(user): change verbs to "verbs=create;update;delete" if you want to enable deletion validation.
-//+kubebuilder:webhook:path=/validate-ingress-baiding-tech-v1beta1-app,mutating=false,failurePolicy=fail,sideEffects=None,groups=ingress.baiding.tech,resources=apps,verbs=create;update,versions=v1beta1,name=vapp.kb.io,admissionReviewVersions=v1
+// DefaultIngressHost returns the host used for an App named name when its
+// Ingress does not configure any.
+func DefaultIngressHost(name string) string {
+	return name + "." + IngressDomain
+}
+
+//+kubebuilder:webhook:path=/validate-ingress-baiding-tech-v1beta1-app,mutating=false,failurePolicy=fail,sideEffects=None,groups=ingress.baiding.tech,resources=apps,verbs=create;update;delete,versions=v1beta1,name=vapp.kb.io,admissionReviewVersions=v1
 
 var _ webhook.Validator = &App{}
 
 // ValidateCreate implements webhook.Validator so a webhook will be registered for the type
 func (r *App) ValidateCreate() error {
-	applog.Info("validate create", "name", r.Name)
-
-	// TODO(user): fill in your validation logic upon object creation.
-	return r.validateApp()
+	_, err := r.validate("create", nil)
+	return err
 }
 
 // ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
 func (r *App) ValidateUpdate(old runtime.Object) error {
-	applog.Info("validate update", "name", r.Name)
+	oldApp, ok := old.(*App)
+	if !ok {
+		return apierrors.NewBadRequest(fmt.Sprintf("expected an App but got a %T", old))
+	}
+	_, err := r.validate("update", oldApp)
+	return err
+}
 
-	// TODO(user): fill in your validation logic upon object update.
-	return r.validateApp()
+// validate runs the validation rules for an operation on r, old being the
+// App replaced by an update. It returns the warnings of the rules with
+// SeverityWarning, and an Invalid error aggregating the other violations.
+func (r *App) validate(operation string, old *App) ([]string, error) {
+	applog.Info("validate "+operation, "name", r.Name)
+
+	allErrs, warnings := runValidationRules(r, old)
+	metrics.RecordAdmission(operation, allErrs)
+	return warnings, r.toInvalidError(allErrs)
 }
 
-func (r *App) validateApp() error {
-	if !r.Spec.EnableService && r.Spec.EnableIngress {
-		return apierrors.NewInvalid(GroupVersion.WithKind("App").GroupKind(), r.Name,
-			field.ErrorList{
-				field.Invalid(field.NewPath("spec", "enableService"), r.Spec.EnableService, "enableIngress为true时，enableService必须为true"),
-			})
+// toInvalidError aggregates allErrs into a single Invalid error, or returns nil if there are none.
+func (r *App) toInvalidError(allErrs field.ErrorList) error {
+	if len(allErrs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(GroupVersion.WithKind("App").GroupKind(), r.Name, allErrs)
+}
+
+func validateEnableService(app *App, fldPath *field.Path) field.ErrorList {
+	if !app.Spec.EnableService && app.Spec.EnableIngress {
+		return field.ErrorList{field.Invalid(fldPath, app.Spec.EnableService, "enable-ingress为true时，enable-service必须为true")}
+	}
+	return nil
+}
+
+func validateServiceName(app *App, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	// Service的名称必须是DNS-1035 label
+	if app.Spec.EnableService {
+		for _, msg := range validation.IsDNS1035Label(app.Name) {
+			allErrs = append(allErrs, field.Invalid(fldPath, app.Name, "must be usable as a Service name: "+msg))
+		}
+	}
+	return allErrs
+}
+
+func validateReplicas(replicas *int32, fldPath *field.Path) field.ErrorList {
+	if replicas != nil && (*replicas < 0 || *replicas > MaxReplicas) {
+		return field.ErrorList{field.Invalid(fldPath, *replicas, fmt.Sprintf("must be between 0 and %d", MaxReplicas))}
+	}
+	return nil
+}
+
+var imageRegexp = regexp.MustCompile(`^` +
+	// 可选的registry, 例如 registry.example.com:5000/
+	`(?:(?:[a-zA-Z0-9]|[a-zA-Z0-9][a-zA-Z0-9-]*[a-zA-Z0-9])(?:\.(?:[a-zA-Z0-9]|[a-zA-Z0-9][a-zA-Z0-9-]*[a-zA-Z0-9]))*(?::[0-9]+)?/)?` +
+	// 镜像名称, 例如 library/nginx
+	`[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*(?:/[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*)*` +
+	// 可选的tag和digest
+	`(?::[\w][\w.-]{0,127})?(?:@[A-Za-z][A-Za-z0-9]*(?:[-_+.][A-Za-z][A-Za-z0-9]*)*:[0-9a-fA-F]{32,})?$`)
+
+func validateImage(image string, fldPath *field.Path) field.ErrorList {
+	if image == "" {
+		return field.ErrorList{field.Required(fldPath, "")}
+	}
+	if !imageRegexp.MatchString(image) {
+		return field.ErrorList{field.Invalid(fldPath, image, "must be a valid image reference, e.g. registry.example.com/nginx:1.21")}
+	}
+	return nil
+}
+
+func validatePorts(ports []AppPort, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	names := sets.NewString()
+	servicePorts := map[string]bool{}
+	containerPorts := map[string]bool{}
+	for i, port := range ports {
+		idxPath := fldPath.Index(i)
+		for _, msg := range validation.IsValidPortName(port.Name) {
+			allErrs = append(allErrs, field.Invalid(idxPath.Child("name"), port.Name, msg))
+		}
+		if names.Has(port.Name) {
+			allErrs = append(allErrs, field.Duplicate(idxPath.Child("name"), port.Name))
+		}
+		names.Insert(port.Name)
+
+		for _, msg := range validation.IsValidPortNum(int(port.Port)) {
+			allErrs = append(allErrs, field.Invalid(idxPath.Child("port"), port.Port, msg))
+		}
+		key := fmt.Sprintf("%d/%s", port.Port, port.Protocol)
+		if servicePorts[key] {
+			allErrs = append(allErrs, field.Duplicate(idxPath.Child("port"), key))
+		}
+		servicePorts[key] = true
+
+		// targetPort为0时默认与port相同
+		targetPort := port.TargetPort
+		if targetPort == 0 {
+			targetPort = port.Port
+		} else {
+			for _, msg := range validation.IsValidPortNum(int(targetPort)) {
+				allErrs = append(allErrs, field.Invalid(idxPath.Child("targetPort"), targetPort, msg))
+			}
+		}
+		key = fmt.Sprintf("%d/%s", targetPort, port.Protocol)
+		if containerPorts[key] {
+			allErrs = append(allErrs, field.Duplicate(idxPath.Child("targetPort"), key))
+		}
+		containerPorts[key] = true
+	}
+	return allErrs
+}
+
+func validateIngress(ingress *AppIngress, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	for i, host := range ingress.Hosts {
+		var msgs []string
+		if strings.HasPrefix(host, "*.") {
+			msgs = validation.IsWildcardDNS1123Subdomain(host)
+		} else {
+			msgs = validation.IsDNS1123Subdomain(host)
+		}
+		for _, msg := range msgs {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("hosts").Index(i), host, msg))
+		}
+	}
+	for i, path := range ingress.Paths {
+		if !strings.HasPrefix(path.Path, "/") {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("paths").Index(i).Child("path"), path.Path, "must be an absolute path"))
+		}
+	}
+	if ingress.IngressClassName != nil {
+		for _, msg := range validation.IsDNS1123Subdomain(*ingress.IngressClassName) {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("ingressClassName"), *ingress.IngressClassName, msg))
+		}
+	}
+	allErrs = append(allErrs, apivalidation.ValidateAnnotations(ingress.Annotations, fldPath.Child("annotations"))...)
+	if tls := ingress.TLS; tls != nil {
+		tlsPath := fldPath.Child("tls")
+		if tls.SecretName != "" {
+			for _, msg := range validation.IsDNS1123Subdomain(tls.SecretName) {
+				allErrs = append(allErrs, field.Invalid(tlsPath.Child("secretName"), tls.SecretName, msg))
+			}
+		}
+		if tls.Issuer != "" && tls.ClusterIssuer != "" {
+			allErrs = append(allErrs, field.Forbidden(tlsPath.Child("clusterIssuer"), "may not be set together with issuer"))
+		}
+	}
+	return allErrs
+}
+
+func validateAutoscaling(as *AppAutoscaling, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	minReplicas := int32(1)
+	if as.MinReplicas != nil {
+		minReplicas = *as.MinReplicas
+		if minReplicas < 1 || minReplicas > MaxReplicas {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("minReplicas"), minReplicas, fmt.Sprintf("must be between 1 and %d", MaxReplicas)))
+		}
+	}
+	if as.MaxReplicas < minReplicas || as.MaxReplicas > MaxReplicas {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("maxReplicas"), as.MaxReplicas, fmt.Sprintf("must be between minReplicas and %d", MaxReplicas)))
+	}
+	if as.TargetCPUUtilizationPercentage != nil && *as.TargetCPUUtilizationPercentage < 1 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("targetCPUUtilizationPercentage"), *as.TargetCPUUtilizationPercentage, "must be greater than 0"))
+	}
+	if as.TargetMemoryUtilizationPercentage != nil && *as.TargetMemoryUtilizationPercentage < 1 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("targetMemoryUtilizationPercentage"), *as.TargetMemoryUtilizationPercentage, "must be greater than 0"))
+	}
+	for i, metric := range as.Metrics {
+		if metric.Type == "" {
+			allErrs = append(allErrs, field.Required(fldPath.Child("metrics").Index(i).Child("type"), ""))
+		}
+	}
+	return allErrs
+}
+
+func validatePodDisruptionBudget(pdb *AppPodDisruptionBudget, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	if pdb.MinAvailable != nil && pdb.MaxUnavailable != nil {
+		allErrs = append(allErrs, field.Forbidden(fldPath.Child("maxUnavailable"), "may not be set together with minAvailable"))
+	}
+	if pdb.MinAvailable != nil {
+		allErrs = append(allErrs, validateIntOrPercent(*pdb.MinAvailable, fldPath.Child("minAvailable"))...)
+	}
+	if pdb.MaxUnavailable != nil {
+		allErrs = append(allErrs, validateIntOrPercent(*pdb.MaxUnavailable, fldPath.Child("maxUnavailable"))...)
+	}
+	return allErrs
+}
+
+func validateStrategy(strategy *AppStrategy, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	if strategy.Canary != nil && strategy.Type != CanaryStrategyType {
+		allErrs = append(allErrs, field.Forbidden(fldPath.Child("canary"), "may only be set when type is Canary"))
+	}
+	if strategy.BlueGreen != nil && strategy.Type != BlueGreenStrategyType {
+		allErrs = append(allErrs, field.Forbidden(fldPath.Child("blueGreen"), "may only be set when type is BlueGreen"))
+	}
+	if strategy.Canary == nil {
+		return allErrs
+	}
+	stepsPath := fldPath.Child("canary", "steps")
+	lastWeight := int32(0)
+	for i, step := range strategy.Canary.Steps {
+		idxPath := stepsPath.Index(i)
+		if step.Weight < 0 || step.Weight > 100 {
+			allErrs = append(allErrs, field.Invalid(idxPath.Child("weight"), step.Weight, "must be between 0 and 100"))
+		} else if step.Weight < lastWeight {
+			allErrs = append(allErrs, field.Invalid(idxPath.Child("weight"), step.Weight, "must not be lower than the weight of the previous step"))
+		}
+		lastWeight = step.Weight
+		if step.Pause != nil && step.Pause.Duration < 0 {
+			allErrs = append(allErrs, field.Invalid(idxPath.Child("pause"), step.Pause.Duration.String(), "must not be negative"))
+		}
+	}
+	return allErrs
+}
+
+func validateRollback(rollback *AppRollback, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	if rollback.ProgressDeadlineSeconds != nil && *rollback.ProgressDeadlineSeconds < 1 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("progressDeadlineSeconds"), *rollback.ProgressDeadlineSeconds, "must be greater than 0"))
+	}
+	if rollback.HistoryLimit != nil && *rollback.HistoryLimit < 1 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("historyLimit"), *rollback.HistoryLimit, "must be greater than 0"))
+	}
+	return allErrs
+}
+
+func validateConfig(config []AppConfigSource, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	mountPaths := sets.NewString()
+	for i, source := range config {
+		idxPath := fldPath.Index(i)
+		switch {
+		case source.ConfigMap == "" && source.Secret == "":
+			allErrs = append(allErrs, field.Required(idxPath, "one of configMap and secret must be set"))
+		case source.ConfigMap != "" && source.Secret != "":
+			allErrs = append(allErrs, field.Forbidden(idxPath.Child("secret"), "may not be set together with configMap"))
+		case source.ConfigMap != "":
+			for _, msg := range validation.IsDNS1123Subdomain(source.ConfigMap) {
+				allErrs = append(allErrs, field.Invalid(idxPath.Child("configMap"), source.ConfigMap, msg))
+			}
+		default:
+			for _, msg := range validation.IsDNS1123Subdomain(source.Secret) {
+				allErrs = append(allErrs, field.Invalid(idxPath.Child("secret"), source.Secret, msg))
+			}
+		}
+		if source.MountPath == "" {
+			continue
+		}
+		if !strings.HasPrefix(source.MountPath, "/") {
+			allErrs = append(allErrs, field.Invalid(idxPath.Child("mountPath"), source.MountPath, "must be an absolute path"))
+		}
+		if mountPaths.Has(source.MountPath) {
+			allErrs = append(allErrs, field.Duplicate(idxPath.Child("mountPath"), source.MountPath))
+		}
+		mountPaths.Insert(source.MountPath)
+	}
+	return allErrs
+}
+
+// validateIntOrPercent checks that value is a non-negative integer or a percentage between 0% and 100%.
+func validateIntOrPercent(value intstr.IntOrString, fldPath *field.Path) field.ErrorList {
+	if value.Type == intstr.Int {
+		if value.IntVal < 0 {
+			return field.ErrorList{field.Invalid(fldPath, value.IntVal, "must be greater than or equal to 0")}
+		}
+		return nil
+	}
+	percent, err := strconv.Atoi(strings.TrimSuffix(value.StrVal, "%"))
+	if err != nil || !strings.HasSuffix(value.StrVal, "%") || percent < 0 || percent > 100 {
+		return field.ErrorList{field.Invalid(fldPath, value.StrVal, "must be an integer or a percentage between 0% and 100%")}
 	}
 	return nil
 }
@@ -85,7 +519,18 @@ func (r *App) validateApp() error {
 func (r *App) ValidateDelete() error {
 	applog.Info("validate delete", "name", r.Name)
 
-	// TODO(user): fill in your validation logic upon object deletion.
-	//不需要做任何处理
-	return nil
+	reason, err := r.deletionProtection(context.Background())
+	if err != nil {
+		return apierrors.NewInternalError(err)
+	}
+	var allErrs field.ErrorList
+	if reason != "" && r.Annotations[ConfirmDeleteAnnotation] != r.Name {
+		allErrs = append(allErrs, field.Forbidden(field.NewPath("metadata", "annotations").Key(ConfirmDeleteAnnotation),
+			fmt.Sprintf("%s, set the annotation %s=%s to confirm its deletion", reason, ConfirmDeleteAnnotation, r.Name)))
+	}
+	metrics.RecordAdmission("delete", allErrs)
+	if len(allErrs) == 0 {
+		return nil
+	}
+	return apierrors.NewForbidden(GroupVersion.WithResource("apps").GroupResource(), r.Name, allErrs.ToAggregate())
 }
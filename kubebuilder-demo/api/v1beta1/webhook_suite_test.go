@@ -26,18 +26,30 @@ import (
 	"time"
 
 	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 
 	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
 	//+kubebuilder:scaffold:imports
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/client-go/rest"
+	"k8s.io/utils/pointer"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/envtest"
 	"sigs.k8s.io/controller-runtime/pkg/envtest/printer"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+
+	ingressv1 "github.com/kubebuilder-demo/api/v1"
+	"github.com/kubebuilder-demo/metrics"
 )
 
 // These tests use Ginkgo (BDD-style Go testing framework). Refer to
@@ -62,30 +74,39 @@ var _ = BeforeSuite(func() {
 
 	ctx, cancel = context.WithCancel(context.TODO())
 
+	scheme := runtime.NewScheme()
+	err := AddToScheme(scheme)
+	Expect(err).NotTo(HaveOccurred())
+
+	err = ingressv1.AddToScheme(scheme)
+	Expect(err).NotTo(HaveOccurred())
+
+	err = admissionv1beta1.AddToScheme(scheme)
+	Expect(err).NotTo(HaveOccurred())
+
+	err = corev1.AddToScheme(scheme)
+	Expect(err).NotTo(HaveOccurred())
+
+	//+kubebuilder:scaffold:scheme
+
 	By("bootstrapping test environment")
 	testEnv = &envtest.Environment{
 		CRDDirectoryPaths:     []string{filepath.Join("..", "..", "config", "crd", "bases")},
 		ErrorIfCRDPathMissing: false,
+		CRDInstallOptions: envtest.CRDInstallOptions{
+			// 根据scheme为App启用conversion webhook
+			Scheme: scheme,
+		},
 		WebhookInstallOptions: envtest.WebhookInstallOptions{
 			Paths: []string{filepath.Join("..", "..", "config", "webhook")},
 		},
 	}
 
-	var err error
 	// cfg is defined in this file globally.
 	cfg, err = testEnv.Start()
 	Expect(err).NotTo(HaveOccurred())
 	Expect(cfg).NotTo(BeNil())
 
-	scheme := runtime.NewScheme()
-	err = AddToScheme(scheme)
-	Expect(err).NotTo(HaveOccurred())
-
-	err = admissionv1beta1.AddToScheme(scheme)
-	Expect(err).NotTo(HaveOccurred())
-
-	//+kubebuilder:scaffold:scheme
-
 	k8sClient, err = client.New(cfg, client.Options{Scheme: scheme})
 	Expect(err).NotTo(HaveOccurred())
 	Expect(k8sClient).NotTo(BeNil())
@@ -133,3 +154,405 @@ var _ = AfterSuite(func() {
 	err := testEnv.Stop()
 	Expect(err).NotTo(HaveOccurred())
 })
+
+var _ = Describe("App defaulting webhook", func() {
+	newApp := func(name string) *App {
+		return &App{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec:       AppSpec{Image: "nginx:1.21"},
+		}
+	}
+
+	It("fills in the defaults", func() {
+		app := newApp("defaults")
+		app.Spec.EnableIngress = true
+		app.Default()
+
+		Expect(app.Spec.Replicas).To(Equal(pointer.Int32(1)))
+		Expect(app.Spec.EnableService).To(BeTrue())
+		Expect(app.Spec.ImagePullPolicy).To(Equal(corev1.PullIfNotPresent))
+		Expect(app.Spec.Ports).To(Equal([]AppPort{{Name: "http", Port: 8080, TargetPort: 80, Protocol: corev1.ProtocolTCP}}))
+		Expect(app.Spec.Ingress.Hosts).To(Equal([]string{"defaults." + IngressDomain}))
+		Expect(app.Spec.Rollback).To(Equal(AppRollback{HistoryLimit: pointer.Int32(DefaultHistoryLimit)}))
+	})
+
+	It("keeps the values set by the user", func() {
+		app := newApp("user-values")
+		app.Spec.Replicas = pointer.Int32(0)
+		app.Spec.ImagePullPolicy = corev1.PullNever
+		app.Spec.Ports = []AppPort{{Port: 9090}}
+		app.Default()
+
+		Expect(app.Spec.Replicas).To(Equal(pointer.Int32(0)))
+		Expect(app.Spec.EnableService).To(BeFalse())
+		Expect(app.Spec.ImagePullPolicy).To(Equal(corev1.PullNever))
+		Expect(app.Spec.Ports).To(Equal([]AppPort{{Name: "port-9090", Port: 9090, TargetPort: 9090, Protocol: corev1.ProtocolTCP}}))
+		Expect(app.Spec.Ingress.Hosts).To(BeEmpty())
+	})
+
+	It("defaults the IngressClass configured for the manager", func() {
+		IngressClassName = "nginx"
+		defer func() { IngressClassName = "" }()
+
+		app := newApp("ingress-class")
+		app.Spec.EnableIngress = true
+		app.Default()
+		Expect(app.Spec.Ingress.IngressClassName).To(Equal(pointer.String("nginx")))
+
+		app = newApp("ingress-class-user")
+		app.Spec.EnableIngress = true
+		app.Spec.Ingress.IngressClassName = pointer.String("traefik")
+		app.Default()
+		Expect(app.Spec.Ingress.IngressClassName).To(Equal(pointer.String("traefik")))
+	})
+
+	It("defaults the autoscaling limits and targets", func() {
+		app := newApp("autoscaling")
+		app.Spec.Autoscaling = &AppAutoscaling{MaxReplicas: 5}
+		app.Default()
+		Expect(app.Spec.Autoscaling).To(Equal(&AppAutoscaling{
+			MinReplicas:                    pointer.Int32(1),
+			MaxReplicas:                    5,
+			TargetCPUUtilizationPercentage: pointer.Int32(DefaultTargetCPUUtilizationPercentage),
+		}))
+
+		app = newApp("autoscaling-memory")
+		app.Spec.Autoscaling = &AppAutoscaling{MaxReplicas: 5, TargetMemoryUtilizationPercentage: pointer.Int32(70)}
+		app.Default()
+		Expect(app.Spec.Autoscaling.TargetCPUUtilizationPercentage).To(BeNil())
+	})
+
+	It("defaults the disruption budget to one unavailable Pod", func() {
+		app := newApp("pdb")
+		app.Spec.PodDisruptionBudget = &AppPodDisruptionBudget{}
+		app.Default()
+		maxUnavailable := intstr.FromInt(1)
+		Expect(app.Spec.PodDisruptionBudget).To(Equal(&AppPodDisruptionBudget{MaxUnavailable: &maxUnavailable}))
+	})
+
+	It("defaults the rollout strategy", func() {
+		app := newApp("strategy")
+		app.Default()
+		Expect(app.Spec.Strategy).To(Equal(AppStrategy{Type: RollingUpdateStrategyType}))
+
+		app = newApp("canary")
+		app.Spec.Strategy.Type = CanaryStrategyType
+		app.Default()
+		Expect(app.Spec.Strategy.Canary).To(Equal(&AppCanaryStrategy{Steps: []AppCanaryStep{{Weight: DefaultCanaryWeight}}}))
+	})
+
+	It("defaults the type of the overrides to strategic merge", func() {
+		app := newApp("overrides")
+		app.Spec.Overrides.Service = []AppPatch{{Patch: "spec: {type: NodePort}"}, {Type: JSONPatchType, Patch: "[]"}}
+		app.Default()
+		Expect(app.Spec.Overrides.Service).To(Equal([]AppPatch{
+			{Type: StrategicMergePatchType, Patch: "spec: {type: NodePort}"},
+			{Type: JSONPatchType, Patch: "[]"},
+		}))
+	})
+
+	table.DescribeTable("defaults the image pull policy from the image tag",
+		func(image string, policy corev1.PullPolicy) {
+			app := newApp("pull-policy")
+			app.Spec.Image = image
+			app.Default()
+			Expect(app.Spec.ImagePullPolicy).To(Equal(policy))
+		},
+		table.Entry("no tag", "nginx", corev1.PullAlways),
+		table.Entry("latest tag", "nginx:latest", corev1.PullAlways),
+		table.Entry("registry with port", "registry:5000/nginx", corev1.PullAlways),
+		table.Entry("versioned tag", "registry:5000/nginx:1.21", corev1.PullIfNotPresent),
+		table.Entry("digest", "nginx@sha256:0d17b565c37bcbd895e9d92315a05c1c3c9a29f762b011a10c54a66cd53c9b31", corev1.PullIfNotPresent),
+	)
+
+	It("is idempotent when applied twice", func() {
+		for _, enableIngress := range []bool{false, true} {
+			app := newApp("idempotent")
+			app.Spec.EnableIngress = enableIngress
+			app.Default()
+			once := app.DeepCopy()
+			app.Default()
+			Expect(app).To(Equal(once))
+		}
+	})
+
+	It("returns a stable result when the same manifest is applied again", func() {
+		app := newApp("reapply")
+		app.Spec.EnableIngress = true
+		Expect(k8sClient.Create(ctx, app)).To(Succeed())
+
+		created := &App{}
+		key := types.NamespacedName{Namespace: app.Namespace, Name: app.Name}
+		Expect(k8sClient.Get(ctx, key, created)).To(Succeed())
+		Expect(created.Spec.EnableIngress).To(BeTrue())
+		Expect(created.Spec.EnableService).To(BeTrue())
+
+		reapplied := created.DeepCopy()
+		reapplied.Spec = newApp("reapply").Spec
+		reapplied.Spec.EnableIngress = true
+		Expect(k8sClient.Update(ctx, reapplied)).To(Succeed())
+
+		updated := &App{}
+		Expect(k8sClient.Get(ctx, key, updated)).To(Succeed())
+		Expect(updated.Spec).To(Equal(created.Spec))
+		Expect(k8sClient.Delete(ctx, updated)).To(Succeed())
+	})
+})
+
+var _ = Describe("App validating webhook", func() {
+	validApp := func() *App {
+		app := &App{
+			ObjectMeta: metav1.ObjectMeta{Name: "validation", Namespace: "default"},
+			Spec:       AppSpec{Image: "registry.example.com:5000/team/nginx:1.21", EnableIngress: true},
+		}
+		app.Default()
+		return app
+	}
+
+	fieldsOf := func(err error) []string {
+		var fields []string
+		statusErr, ok := err.(*apierrors.StatusError)
+		Expect(ok).To(BeTrue(), "expected a StatusError but got %v", err)
+		for _, cause := range statusErr.ErrStatus.Details.Causes {
+			fields = append(fields, cause.Field)
+		}
+		return fields
+	}
+
+	It("accepts a valid App", func() {
+		Expect(validApp().ValidateCreate()).To(Succeed())
+	})
+
+	table.DescribeTable("reports every invalid field with its JSON path",
+		func(mutate func(app *App), fields ...string) {
+			app := validApp()
+			mutate(app)
+			err := app.ValidateCreate()
+			Expect(apierrors.IsInvalid(err)).To(BeTrue())
+			Expect(fieldsOf(err)).To(ConsistOf(fields))
+		},
+		table.Entry("ingress without service", func(app *App) { app.Spec.EnableService = false },
+			"spec.enable-service"),
+		table.Entry("invalid image", func(app *App) { app.Spec.Image = "Nginx:1.21" },
+			"spec.image"),
+		table.Entry("missing image", func(app *App) { app.Spec.Image = "" },
+			"spec.image"),
+		table.Entry("replicas out of bounds", func(app *App) { app.Spec.Replicas = pointer.Int32(MaxReplicas + 1) },
+			"spec.replicas"),
+		table.Entry("name not usable for the Service", func(app *App) { app.Name = "my.app" },
+			"metadata.name"),
+		table.Entry("invalid host", func(app *App) { app.Spec.Ingress.Hosts = []string{"app.baiding.tech", "App_1.baiding.tech"} },
+			"spec.ingress.hosts[1]"),
+		table.Entry("relative ingress path", func(app *App) { app.Spec.Ingress.Paths = []AppIngressPath{{Path: "api"}} },
+			"spec.ingress.paths[0].path"),
+		table.Entry("issuer and cluster issuer", func(app *App) {
+			app.Spec.Ingress.TLS = &AppIngressTLS{Issuer: "letsencrypt", ClusterIssuer: "letsencrypt"}
+		}, "spec.ingress.tls.clusterIssuer"),
+		table.Entry("colliding ports", func(app *App) {
+			app.Spec.Ports = []AppPort{
+				{Name: "http", Port: 8080, TargetPort: 80, Protocol: corev1.ProtocolTCP},
+				{Name: "http", Port: 8080, TargetPort: 80, Protocol: corev1.ProtocolTCP},
+				{Name: "metrics", Port: 70000, TargetPort: 9090, Protocol: corev1.ProtocolTCP},
+			}
+		}, "spec.ports[1].name", "spec.ports[1].port", "spec.ports[1].targetPort", "spec.ports[2].port"),
+		table.Entry("autoscaling limits", func(app *App) {
+			app.Spec.Autoscaling = &AppAutoscaling{
+				MinReplicas:                    pointer.Int32(5),
+				MaxReplicas:                    2,
+				TargetCPUUtilizationPercentage: pointer.Int32(0),
+				Metrics:                        []autoscalingv2.MetricSpec{{}},
+			}
+		}, "spec.autoscaling.maxReplicas", "spec.autoscaling.targetCPUUtilizationPercentage", "spec.autoscaling.metrics[0].type"),
+		table.Entry("disruption budget with both limits", func(app *App) {
+			minAvailable := intstr.FromString("120%")
+			maxUnavailable := intstr.FromInt(-1)
+			app.Spec.PodDisruptionBudget = &AppPodDisruptionBudget{MinAvailable: &minAvailable, MaxUnavailable: &maxUnavailable}
+		}, "spec.podDisruptionBudget.minAvailable", "spec.podDisruptionBudget.maxUnavailable", "spec.podDisruptionBudget.maxUnavailable"),
+		table.Entry("canary steps", func(app *App) {
+			app.Spec.Strategy = AppStrategy{
+				Type: CanaryStrategyType,
+				Canary: &AppCanaryStrategy{Steps: []AppCanaryStep{
+					{Weight: 50},
+					{Weight: 10},
+					{Weight: 120, Pause: &metav1.Duration{Duration: -time.Minute}},
+				}},
+				BlueGreen: &AppBlueGreenStrategy{},
+			}
+		}, "spec.strategy.blueGreen", "spec.strategy.canary.steps[1].weight", "spec.strategy.canary.steps[2].weight", "spec.strategy.canary.steps[2].pause"),
+		table.Entry("rollback limits", func(app *App) {
+			app.Spec.Rollback = AppRollback{ProgressDeadlineSeconds: pointer.Int32(0), HistoryLimit: pointer.Int32(0)}
+		}, "spec.rollback.progressDeadlineSeconds", "spec.rollback.historyLimit"),
+		table.Entry("config sources", func(app *App) {
+			app.Spec.Config = []AppConfigSource{
+				{ConfigMap: "settings", MountPath: "/etc/app"},
+				{},
+				{ConfigMap: "settings", Secret: "credentials"},
+				{Secret: "Credentials", MountPath: "/etc/app"},
+			}
+		}, "spec.config[1]", "spec.config[2].secret", "spec.config[3].secret", "spec.config[3].mountPath"),
+		table.Entry("unsupported patch type", func(app *App) {
+			app.Spec.Overrides.Deployment = []AppPatch{{Type: StrategicMergePatchType, Patch: "spec: {replica: 2}"}}
+			app.Spec.Overrides.Ingress = []AppPatch{{Type: "Merge", Patch: "{}"}}
+		}, "spec.overrides.ingress[0].type"),
+		table.Entry("overrides not applying", func(app *App) {
+			app.Spec.Overrides.Deployment = []AppPatch{
+				{Type: StrategicMergePatchType, Patch: "spec: {paused: true}"},
+				{Type: StrategicMergePatchType, Patch: "spec: {replica: 2}"},
+			}
+			app.Spec.Overrides.Service = []AppPatch{{Type: JSONPatchType, Patch: `[{"op": "remove", "path": "/spec/clusterIP"}]`}}
+		}, "spec.overrides.deployment[1].patch", "spec.overrides.service[0].patch"),
+		table.Entry("overrides changing the selector or the owner", func(app *App) {
+			app.Spec.Overrides.Deployment = []AppPatch{{Type: StrategicMergePatchType, Patch: "spec: {template: {metadata: {labels: {app: other}}}}"}}
+			app.Spec.Overrides.Service = []AppPatch{{Type: StrategicMergePatchType, Patch: "spec: {selector: {tier: web}}"}}
+			app.Spec.Overrides.Ingress = []AppPatch{{Type: JSONPatchType, Patch: `[{"op": "add", "path": "/metadata/ownerReferences", "value": [{"apiVersion": "v1", "kind": "ConfigMap", "name": "owner", "uid": "1"}]}]`}}
+		}, "spec.overrides.deployment", "spec.overrides.service", "spec.overrides.ingress"),
+		table.Entry("dependencies", func(app *App) {
+			app.Spec.DependsOn = []string{"database", "Cache", "database", app.Name}
+		}, "spec.dependsOn[1]", "spec.dependsOn[2]", "spec.dependsOn[3]"),
+	)
+
+	It("rejects dependency cycles", func() {
+		for name, dependsOn := range map[string][]string{"cycle-db": {"cycle-cache"}, "cycle-cache": {"cycle-api", "cycle-queue"}} {
+			app := validApp()
+			app.Name = name
+			Expect(k8sClient.Create(ctx, app)).To(Succeed())
+			app.Spec.DependsOn = dependsOn
+			Expect(k8sClient.Update(ctx, app)).To(Succeed())
+		}
+
+		app := validApp()
+		app.Name = "cycle-api"
+		app.Spec.DependsOn = []string{"cycle-queue", "cycle-db"}
+		err := app.ValidateCreate()
+		Expect(apierrors.IsInvalid(err)).To(BeTrue())
+		Expect(fieldsOf(err)).To(ConsistOf("spec.dependsOn[1]"))
+		Expect(err.Error()).To(ContainSubstring("cycle-api -> cycle-db -> cycle-cache -> cycle-api"))
+
+		app.Spec.DependsOn = []string{"cycle-queue"}
+		Expect(app.ValidateCreate()).To(Succeed())
+	})
+
+	It("applies the overrides to the generated objects", func() {
+		app := validApp()
+		app.Spec.Overrides.Deployment = []AppPatch{
+			{Type: StrategicMergePatchType, Patch: "spec:\n  template:\n    spec:\n      containers:\n      - name: sidecar\n        image: envoyproxy/envoy:v1.22.0\n"},
+			{Type: JSONPatchType, Patch: `[{"op": "add", "path": "/spec/template/spec/nodeSelector", "value": {"disktype": "ssd"}}]`},
+		}
+		Expect(app.ValidateCreate()).To(Succeed())
+
+		deployment, err := RenderDeployment(app, "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ApplyPatches(deployment, app.Spec.Overrides.Deployment)).To(Succeed())
+		images := map[string]string{}
+		for _, container := range deployment.Spec.Template.Spec.Containers {
+			images[container.Name] = container.Image
+		}
+		Expect(images).To(Equal(map[string]string{app.Name: app.Spec.Image, "sidecar": "envoyproxy/envoy:v1.22.0"}))
+		Expect(deployment.Spec.Template.Spec.NodeSelector).To(Equal(map[string]string{"disktype": "ssd"}))
+	})
+
+	It("validates the overrides against the rendered objects", func() {
+		app := validApp()
+		app.Spec.Config = []AppConfigSource{{ConfigMap: "settings", MountPath: "/etc/app"}}
+		app.Spec.Overrides.Deployment = []AppPatch{
+			{Type: JSONPatchType, Patch: `[{"op": "add", "path": "/spec/template/spec/volumes/0/configMap/defaultMode", "value": 288}]`},
+			{Type: JSONPatchType, Patch: `[{"op": "replace", "path": "/spec/template/metadata/annotations/config.baiding.tech~1hash", "value": "pinned"}]`},
+		}
+		Expect(app.ValidateCreate()).To(Succeed())
+
+		deployment, err := RenderDeployment(app, "hash")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ApplyPatches(deployment, app.Spec.Overrides.Deployment)).To(Succeed())
+		Expect(deployment.Spec.Template.Spec.Volumes[0].ConfigMap.DefaultMode).To(Equal(pointer.Int32(288)))
+	})
+
+	It("renders the Ingress of wildcard hosts", func() {
+		app := validApp()
+		app.Spec.Ingress.Hosts = []string{"*.example.com", "example.com"}
+		app.Spec.Ingress.TLS = &AppIngressTLS{}
+		Expect(app.ValidateCreate()).To(Succeed())
+
+		ingress, err := RenderIngress(app)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ingress.Spec.Rules).To(HaveLen(2))
+		Expect(ingress.Spec.Rules[0].Host).To(Equal("*.example.com"))
+		Expect(ingress.Spec.TLS[0].Hosts).To(Equal([]string{"*.example.com", "example.com"}))
+	})
+
+	It("rejects changing the strategy type during a rollout", func() {
+		old := validApp()
+		old.Status.Rollout = &AppRolloutStatus{Phase: RolloutProgressing}
+		app := validApp()
+		app.Spec.Strategy = AppStrategy{Type: BlueGreenStrategyType}
+		app.Default()
+		err := app.ValidateUpdate(old)
+		Expect(apierrors.IsInvalid(err)).To(BeTrue())
+		Expect(fieldsOf(err)).To(ConsistOf("spec.strategy.type"))
+	})
+
+	It("counts the admission decisions by reason", func() {
+		allowed := metrics.WebhookAdmissions.WithLabelValues("create", metrics.DecisionAllowed, "", "")
+		denied := metrics.WebhookAdmissions.WithLabelValues("create", metrics.DecisionDenied, "FieldValueInvalid", "spec.image")
+		allowedBefore, deniedBefore := testutil.ToFloat64(allowed), testutil.ToFloat64(denied)
+
+		Expect(validApp().ValidateCreate()).To(Succeed())
+		app := validApp()
+		app.Spec.Image = "Nginx"
+		Expect(app.ValidateCreate()).NotTo(Succeed())
+
+		Expect(testutil.ToFloat64(allowed)).To(Equal(allowedBefore + 1))
+		Expect(testutil.ToFloat64(denied)).To(Equal(deniedBefore + 1))
+	})
+
+	It("rejects invalid Apps through the API server", func() {
+		app := validApp()
+		app.Spec.Image = "Nginx"
+		err := k8sClient.Create(ctx, app)
+		Expect(apierrors.IsInvalid(err) || apierrors.IsForbidden(err)).To(BeTrue(), "unexpected error %v", err)
+		Expect(err.Error()).To(ContainSubstring("spec.image"))
+	})
+})
+
+var _ = Describe("App deletion protection", func() {
+	newApp := func(namespace, name string) *App {
+		app := &App{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Spec:       AppSpec{Image: "nginx:1.21"},
+		}
+		app.Default()
+		return app
+	}
+
+	It("allows deleting unprotected Apps", func() {
+		Expect(newApp("default", "unprotected").ValidateDelete()).To(Succeed())
+	})
+
+	It("requires a confirmation to delete Apps with the protected label", func() {
+		app := newApp("default", "protected")
+		app.Labels = map[string]string{ProtectedLabel: "true"}
+		err := app.ValidateDelete()
+		Expect(apierrors.IsForbidden(err)).To(BeTrue(), "unexpected error %v", err)
+		Expect(err.Error()).To(ContainSubstring("protected by the label " + ProtectedLabel))
+		Expect(err.Error()).To(ContainSubstring(ConfirmDeleteAnnotation + "=protected"))
+
+		app.Annotations = map[string]string{ConfirmDeleteAnnotation: "other"}
+		Expect(app.ValidateDelete()).NotTo(Succeed())
+		app.Annotations[ConfirmDeleteAnnotation] = "protected"
+		Expect(app.ValidateDelete()).To(Succeed())
+	})
+
+	It("protects the Apps of production namespaces through the API server", func() {
+		ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "production", Labels: map[string]string{"environment": "production"}}}
+		Expect(k8sClient.Create(ctx, ns)).To(Succeed())
+		app := newApp(ns.Name, "shop")
+		Expect(k8sClient.Create(ctx, app)).To(Succeed())
+
+		err := k8sClient.Delete(ctx, app)
+		Expect(apierrors.IsForbidden(err)).To(BeTrue(), "unexpected error %v", err)
+		Expect(err.Error()).To(ContainSubstring("the namespace production is a production namespace"))
+
+		app.Annotations = map[string]string{ConfirmDeleteAnnotation: app.Name}
+		Expect(k8sClient.Update(ctx, app)).To(Succeed())
+		Expect(k8sClient.Delete(ctx, app)).To(Succeed())
+	})
+})
@@ -0,0 +1,457 @@
+// Package certs generates and rotates the serving certificate of the webhook
+// server without cert-manager. The certificates are signed by a self-signed
+// CA, stored in a Secret shared by the replicas of the manager, and the CA is
+// injected into the webhook configurations and the conversion webhook of the
+// CRDs.
+package certs
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// Keys of the Secret storing the certificates.
+const (
+	CACertKey         = "ca.crt"
+	CAKeyKey          = "ca.key"
+	PreviousCACertKey = "ca-previous.crt"
+	CertKey           = corev1.TLSCertKey
+	KeyKey            = corev1.TLSPrivateKeyKey
+)
+
+// Defaults of the Manager.
+const (
+	DefaultValidity      = 365 * 24 * time.Hour
+	DefaultRotateBefore  = 30 * 24 * time.Hour
+	DefaultCheckInterval = time.Hour
+
+	// caValidityFactor is the lifetime of the CA relative to the serving certificate.
+	caValidityFactor = 10
+)
+
+var log = logf.Log.WithName("certs")
+
+// Manager generates the serving certificate of the webhook server and keeps
+// it valid. Ensure must be called once before the webhook server is started,
+// Start then rotates the certificate before it expires.
+type Manager struct {
+	// Client writes the Secret, the webhook configurations and the CRDs.
+	Client client.Client
+	// Reader reads the Secret, the webhook configurations and the CRDs. It is
+	// usually not backed by the cache, since Ensure runs before the cache is started.
+	Reader client.Reader
+
+	// Secret stores the CA and the serving certificate.
+	Secret types.NamespacedName
+	// CertDir is the directory of the webhook server the certificate is written to.
+	CertDir string
+	// Hosts are the DNS names and IP addresses the certificate is valid for.
+	Hosts []string
+
+	// MutatingWebhookConfigurations, ValidatingWebhookConfigurations and
+	// CustomResourceDefinitions are the names of the objects the CA is injected into.
+	MutatingWebhookConfigurations   []string
+	ValidatingWebhookConfigurations []string
+	CustomResourceDefinitions       []string
+
+	// Validity of the serving certificate. Defaults to DefaultValidity.
+	Validity time.Duration
+	// RotateBefore is the time before the expiry at which the certificates
+	// are rotated. Defaults to DefaultRotateBefore.
+	RotateBefore time.Duration
+	// CheckInterval is the interval at which the certificates are checked.
+	// Defaults to DefaultCheckInterval.
+	CheckInterval time.Duration
+
+	// now returns the current time, it is replaced in the tests.
+	now func() time.Time
+}
+
+// Start checks the certificates every CheckInterval until ctx is done. It
+// implements manager.Runnable.
+func (m *Manager) Start(ctx context.Context) error {
+	ticker := time.NewTicker(m.checkInterval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			// 出错时等待下一次检查, 证书提前RotateBefore轮换, 有足够的时间重试
+			if err := m.Ensure(ctx); err != nil {
+				log.Error(err, "unable to ensure the webhook certificates")
+			}
+		}
+	}
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable. Every replica
+// writes the certificate of its own webhook server.
+func (m *Manager) NeedLeaderElection() bool {
+	return false
+}
+
+// Ensure generates or rotates the certificates if needed, writes the serving
+// certificate to CertDir and injects the CA.
+func (m *Manager) Ensure(ctx context.Context) error {
+	var secret *corev1.Secret
+	// 多个副本同时创建或轮换时, 以先写入Secret的为准
+	err := retry.OnError(retry.DefaultRetry, func(err error) bool {
+		return apierrors.IsConflict(err) || apierrors.IsAlreadyExists(err)
+	}, func() error {
+		var err error
+		secret, err = m.reconcileSecret(ctx)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := m.writeCertificate(secret); err != nil {
+		return err
+	}
+	return m.injectCA(ctx, caBundle(secret, m.currentTime()))
+}
+
+// reconcileSecret creates the Secret or rotates the certificates it stores.
+func (m *Manager) reconcileSecret(ctx context.Context) (*corev1.Secret, error) {
+	secret := &corev1.Secret{}
+	err := m.Reader.Get(ctx, m.Secret, secret)
+	if apierrors.IsNotFound(err) {
+		secret = &corev1.Secret{}
+		secret.Namespace, secret.Name = m.Secret.Namespace, m.Secret.Name
+		secret.Type = corev1.SecretTypeTLS
+		if err := m.rotate(secret, true); err != nil {
+			return nil, err
+		}
+		log.Info("creating the webhook certificates", "secret", m.Secret)
+		return secret, m.Client.Create(ctx, secret)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rotateCA, rotateCert := m.needsRotation(secret)
+	if !rotateCA && !rotateCert {
+		return secret, nil
+	}
+	if err := m.rotate(secret, rotateCA); err != nil {
+		return nil, err
+	}
+	log.Info("rotating the webhook certificates", "secret", m.Secret, "ca", rotateCA)
+	return secret, m.Client.Update(ctx, secret)
+}
+
+// needsRotation reports whether the CA, or only the serving certificate
+// stored in secret must be rotated.
+func (m *Manager) needsRotation(secret *corev1.Secret) (rotateCA, rotateCert bool) {
+	now := m.currentTime()
+	ca, err := parseCertificate(secret.Data[CACertKey])
+	if err != nil || now.Add(m.rotateBefore()).After(ca.NotAfter) {
+		return true, true
+	}
+	if _, err := parseKey(secret.Data[CAKeyKey]); err != nil {
+		return true, true
+	}
+	cert, err := parseCertificate(secret.Data[CertKey])
+	if err != nil || now.Add(m.rotateBefore()).After(cert.NotAfter) {
+		return false, true
+	}
+	if _, err := parseKey(secret.Data[KeyKey]); err != nil {
+		return false, true
+	}
+	// 证书不是当前CA签发的, 或者hosts发生了变化
+	if err := cert.CheckSignatureFrom(ca); err != nil {
+		return false, true
+	}
+	dnsNames, ips := splitHosts(m.Hosts)
+	certIPs := sets.NewString()
+	for _, ip := range cert.IPAddresses {
+		certIPs.Insert(ip.String())
+	}
+	if !sets.NewString(cert.DNSNames...).Equal(sets.NewString(dnsNames...)) || !certIPs.Equal(sets.NewString(ips...)) {
+		return false, true
+	}
+	return false, false
+}
+
+// rotate generates a new serving certificate in secret, and a new CA if rotateCA is set.
+func (m *Manager) rotate(secret *corev1.Secret, rotateCA bool) error {
+	now := m.currentTime()
+	if secret.Data == nil {
+		secret.Data = map[string][]byte{}
+	}
+	if rotateCA {
+		caCert, caKey, err := newCA(now, caValidityFactor*m.validity())
+		if err != nil {
+			return err
+		}
+		// 保留旧的CA, 在其他副本更新证书之前, 旧证书仍然可以通过校验
+		delete(secret.Data, PreviousCACertKey)
+		if old, err := parseCertificate(secret.Data[CACertKey]); err == nil && now.Before(old.NotAfter) {
+			secret.Data[PreviousCACertKey] = secret.Data[CACertKey]
+		}
+		secret.Data[CACertKey], secret.Data[CAKeyKey] = caCert, caKey
+	}
+
+	ca, err := parseCertificate(secret.Data[CACertKey])
+	if err != nil {
+		return err
+	}
+	caKey, err := parseKey(secret.Data[CAKeyKey])
+	if err != nil {
+		return err
+	}
+	cert, key, err := newServingCertificate(now, m.validity(), m.Hosts, ca, caKey)
+	if err != nil {
+		return err
+	}
+	secret.Data[CertKey], secret.Data[KeyKey] = cert, key
+	return nil
+}
+
+// writeCertificate writes the serving certificate of secret to CertDir. The
+// files are only written when they changed, the webhook server reloads them.
+func (m *Manager) writeCertificate(secret *corev1.Secret) error {
+	if err := os.MkdirAll(m.CertDir, 0o700); err != nil {
+		return err
+	}
+	// 先写key再写证书, 证书变化时webhook server才会重新加载
+	for _, key := range []string{KeyKey, CertKey} {
+		path := filepath.Join(m.CertDir, key)
+		if current, err := os.ReadFile(path); err == nil && bytes.Equal(current, secret.Data[key]) {
+			continue
+		}
+		if err := os.WriteFile(path, secret.Data[key], 0o600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// injectCA sets bundle as the CA of the webhook configurations and of the
+// conversion webhook of the CRDs.
+func (m *Manager) injectCA(ctx context.Context, bundle []byte) error {
+	for _, name := range m.MutatingWebhookConfigurations {
+		config := &admissionregistrationv1.MutatingWebhookConfiguration{}
+		err := m.update(ctx, name, config, func() bool {
+			changed := false
+			for i := range config.Webhooks {
+				changed = setCABundle(&config.Webhooks[i].ClientConfig.CABundle, bundle) || changed
+			}
+			return changed
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for _, name := range m.ValidatingWebhookConfigurations {
+		config := &admissionregistrationv1.ValidatingWebhookConfiguration{}
+		err := m.update(ctx, name, config, func() bool {
+			changed := false
+			for i := range config.Webhooks {
+				changed = setCABundle(&config.Webhooks[i].ClientConfig.CABundle, bundle) || changed
+			}
+			return changed
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for _, name := range m.CustomResourceDefinitions {
+		crd := &apiextensionsv1.CustomResourceDefinition{}
+		err := m.update(ctx, name, crd, func() bool {
+			conversion := crd.Spec.Conversion
+			if conversion == nil || conversion.Webhook == nil || conversion.Webhook.ClientConfig == nil {
+				return false
+			}
+			return setCABundle(&conversion.Webhook.ClientConfig.CABundle, bundle)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// update reads the cluster scoped object name into obj, applies mutate and
+// updates obj if mutate changed it.
+func (m *Manager) update(ctx context.Context, name string, obj client.Object, mutate func() bool) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		if err := m.Reader.Get(ctx, types.NamespacedName{Name: name}, obj); err != nil {
+			return err
+		}
+		if !mutate() {
+			return nil
+		}
+		log.Info("injecting the CA", "kind", fmt.Sprintf("%T", obj), "name", name)
+		return m.Client.Update(ctx, obj)
+	})
+}
+
+func setCABundle(caBundle *[]byte, bundle []byte) bool {
+	if bytes.Equal(*caBundle, bundle) {
+		return false
+	}
+	*caBundle = bundle
+	return true
+}
+
+// caBundle returns the CAs of secret trusted by the API server.
+func caBundle(secret *corev1.Secret, now time.Time) []byte {
+	bundle := append([]byte{}, secret.Data[CACertKey]...)
+	if previous, err := parseCertificate(secret.Data[PreviousCACertKey]); err == nil && now.Before(previous.NotAfter) {
+		bundle = append(bundle, secret.Data[PreviousCACertKey]...)
+	}
+	return bundle
+}
+
+func (m *Manager) currentTime() time.Time {
+	if m.now != nil {
+		return m.now()
+	}
+	return time.Now()
+}
+
+func (m *Manager) validity() time.Duration {
+	if m.Validity > 0 {
+		return m.Validity
+	}
+	return DefaultValidity
+}
+
+func (m *Manager) rotateBefore() time.Duration {
+	if m.RotateBefore > 0 {
+		return m.RotateBefore
+	}
+	return DefaultRotateBefore
+}
+
+func (m *Manager) checkInterval() time.Duration {
+	if m.CheckInterval > 0 {
+		return m.CheckInterval
+	}
+	return DefaultCheckInterval
+}
+
+// newCA returns a self-signed CA certificate and its key, PEM encoded.
+func newCA(now time.Time, validity time.Duration) ([]byte, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          newSerialNumber(),
+		Subject:               pkix.Name{CommonName: "kubebuilder-demo-webhook-ca"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(validity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return encode(der, key)
+}
+
+// newServingCertificate returns a certificate for hosts signed by ca and its key, PEM encoded.
+func newServingCertificate(now time.Time, validity time.Duration, hosts []string, ca *x509.Certificate, caKey *ecdsa.PrivateKey) ([]byte, []byte, error) {
+	if len(hosts) == 0 {
+		return nil, nil, errors.New("the webhook certificate needs at least one host")
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	dnsNames, ips := splitHosts(hosts)
+	template := &x509.Certificate{
+		SerialNumber: newSerialNumber(),
+		Subject:      pkix.Name{CommonName: hosts[0]},
+		DNSNames:     dnsNames,
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(validity),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, ip := range ips {
+		template.IPAddresses = append(template.IPAddresses, net.ParseIP(ip))
+	}
+	// 证书不能超过CA的有效期
+	if template.NotAfter.After(ca.NotAfter) {
+		template.NotAfter = ca.NotAfter
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return encode(der, key)
+}
+
+// splitHosts splits hosts into DNS names and IP addresses.
+func splitHosts(hosts []string) (dnsNames, ips []string) {
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			ips = append(ips, ip.String())
+		} else {
+			dnsNames = append(dnsNames, host)
+		}
+	}
+	return dnsNames, ips
+}
+
+func newSerialNumber() *big.Int {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return big.NewInt(time.Now().UnixNano())
+	}
+	return serial
+}
+
+func encode(der []byte, key *ecdsa.PrivateKey) ([]byte, []byte, error) {
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), nil
+}
+
+func parseCertificate(data []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("no PEM encoded certificate found")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+func parseKey(data []byte) (*ecdsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "EC PRIVATE KEY" {
+		return nil, errors.New("no PEM encoded EC private key found")
+	}
+	return x509.ParseECPrivateKey(block.Bytes)
+}
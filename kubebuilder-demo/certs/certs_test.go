@@ -0,0 +1,168 @@
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Manager", func() {
+	var (
+		ctx     context.Context
+		c       client.Client
+		m       *Manager
+		now     time.Time
+		certDir string
+	)
+
+	secretKey := types.NamespacedName{Namespace: "system", Name: "webhook-server-cert"}
+
+	getSecret := func() *corev1.Secret {
+		secret := &corev1.Secret{}
+		Expect(c.Get(ctx, secretKey, secret)).To(Succeed())
+		return secret
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(apiextensionsv1.AddToScheme(scheme)).To(Succeed())
+		webhook := admissionregistrationv1.WebhookClientConfig{Service: &admissionregistrationv1.ServiceReference{Namespace: "system", Name: "webhook-service"}}
+		c = fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+			&admissionregistrationv1.MutatingWebhookConfiguration{
+				ObjectMeta: metav1.ObjectMeta{Name: "mutating"},
+				Webhooks:   []admissionregistrationv1.MutatingWebhook{{Name: "mapp.kb.io", ClientConfig: webhook}},
+			},
+			&admissionregistrationv1.ValidatingWebhookConfiguration{
+				ObjectMeta: metav1.ObjectMeta{Name: "validating"},
+				Webhooks:   []admissionregistrationv1.ValidatingWebhook{{Name: "vapp.kb.io", ClientConfig: webhook}},
+			},
+			&apiextensionsv1.CustomResourceDefinition{
+				ObjectMeta: metav1.ObjectMeta{Name: "apps.ingress.baiding.tech"},
+				Spec: apiextensionsv1.CustomResourceDefinitionSpec{Conversion: &apiextensionsv1.CustomResourceConversion{
+					Strategy: apiextensionsv1.WebhookConverter,
+					Webhook: &apiextensionsv1.WebhookConversion{ClientConfig: &apiextensionsv1.WebhookClientConfig{
+						Service: &apiextensionsv1.ServiceReference{Namespace: "system", Name: "webhook-service"},
+					}},
+				}},
+			},
+		).Build()
+
+		var err error
+		certDir, err = os.MkdirTemp("", "certs")
+		Expect(err).NotTo(HaveOccurred())
+		now = time.Now()
+		m = &Manager{
+			Client:                          c,
+			Reader:                          c,
+			Secret:                          secretKey,
+			CertDir:                         certDir,
+			Hosts:                           []string{"webhook-service.system.svc", "127.0.0.1"},
+			MutatingWebhookConfigurations:   []string{"mutating"},
+			ValidatingWebhookConfigurations: []string{"validating"},
+			CustomResourceDefinitions:       []string{"apps.ingress.baiding.tech"},
+			now:                             func() time.Time { return now },
+		}
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(certDir)).To(Succeed())
+	})
+
+	It("generates the certificates and injects the CA", func() {
+		Expect(m.Ensure(ctx)).To(Succeed())
+
+		secret := getSecret()
+		ca, err := parseCertificate(secret.Data[CACertKey])
+		Expect(err).NotTo(HaveOccurred())
+		pool := x509.NewCertPool()
+		pool.AddCert(ca)
+
+		// webhook server使用的证书可以通过CA的校验
+		pair, err := tls.LoadX509KeyPair(filepath.Join(certDir, CertKey), filepath.Join(certDir, KeyKey))
+		Expect(err).NotTo(HaveOccurred())
+		cert, err := x509.ParseCertificate(pair.Certificate[0])
+		Expect(err).NotTo(HaveOccurred())
+		for _, host := range []string{"webhook-service.system.svc", "127.0.0.1"} {
+			_, err = cert.Verify(x509.VerifyOptions{DNSName: host, Roots: pool})
+			Expect(err).NotTo(HaveOccurred(), "host %s", host)
+		}
+
+		mutating := &admissionregistrationv1.MutatingWebhookConfiguration{}
+		Expect(c.Get(ctx, types.NamespacedName{Name: "mutating"}, mutating)).To(Succeed())
+		Expect(mutating.Webhooks[0].ClientConfig.CABundle).To(Equal(secret.Data[CACertKey]))
+		validating := &admissionregistrationv1.ValidatingWebhookConfiguration{}
+		Expect(c.Get(ctx, types.NamespacedName{Name: "validating"}, validating)).To(Succeed())
+		Expect(validating.Webhooks[0].ClientConfig.CABundle).To(Equal(secret.Data[CACertKey]))
+		crd := &apiextensionsv1.CustomResourceDefinition{}
+		Expect(c.Get(ctx, types.NamespacedName{Name: "apps.ingress.baiding.tech"}, crd)).To(Succeed())
+		Expect(crd.Spec.Conversion.Webhook.ClientConfig.CABundle).To(Equal(secret.Data[CACertKey]))
+	})
+
+	It("keeps valid certificates", func() {
+		Expect(m.Ensure(ctx)).To(Succeed())
+		secret := getSecret()
+
+		now = now.Add(DefaultValidity / 2)
+		Expect(m.Ensure(ctx)).To(Succeed())
+		Expect(getSecret().ResourceVersion).To(Equal(secret.ResourceVersion))
+	})
+
+	It("rotates the serving certificate before it expires", func() {
+		Expect(m.Ensure(ctx)).To(Succeed())
+		secret := getSecret()
+
+		now = now.Add(DefaultValidity - DefaultRotateBefore + time.Hour)
+		Expect(m.Ensure(ctx)).To(Succeed())
+		rotated := getSecret()
+		Expect(rotated.Data[CertKey]).NotTo(Equal(secret.Data[CertKey]))
+		Expect(rotated.Data[CACertKey]).To(Equal(secret.Data[CACertKey]))
+
+		written, err := os.ReadFile(filepath.Join(certDir, CertKey))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(written).To(Equal(rotated.Data[CertKey]))
+	})
+
+	It("rotates the serving certificate when the hosts change", func() {
+		Expect(m.Ensure(ctx)).To(Succeed())
+		secret := getSecret()
+
+		m.Hosts = append(m.Hosts, "webhook-service.system.svc.cluster.local")
+		Expect(m.Ensure(ctx)).To(Succeed())
+		cert, err := parseCertificate(getSecret().Data[CertKey])
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cert.DNSNames).To(ContainElement("webhook-service.system.svc.cluster.local"))
+		Expect(getSecret().Data[CACertKey]).To(Equal(secret.Data[CACertKey]))
+	})
+
+	It("trusts the previous CA after rotating the CA", func() {
+		Expect(m.Ensure(ctx)).To(Succeed())
+		secret := getSecret()
+
+		now = now.Add(caValidityFactor*DefaultValidity - DefaultRotateBefore + time.Hour)
+		Expect(m.Ensure(ctx)).To(Succeed())
+		rotated := getSecret()
+		Expect(rotated.Data[CACertKey]).NotTo(Equal(secret.Data[CACertKey]))
+		Expect(rotated.Data[PreviousCACertKey]).To(Equal(secret.Data[CACertKey]))
+
+		mutating := &admissionregistrationv1.MutatingWebhookConfiguration{}
+		Expect(c.Get(ctx, types.NamespacedName{Name: "mutating"}, mutating)).To(Succeed())
+		Expect(mutating.Webhooks[0].ClientConfig.CABundle).To(Equal(append(append([]byte{}, rotated.Data[CACertKey]...), secret.Data[CACertKey]...)))
+	})
+})
@@ -0,0 +1,125 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	ingressv1beta1 "github.com/kubebuilder-demo/api/v1beta1"
+	"github.com/kubebuilder-demo/controllers/utils"
+)
+
+// ReasonAutoscalingUnavailable is the reason of the Autoscaling condition and
+// Event of an App with autoscaling enabled when the cluster does not serve
+// the autoscaling/v2 API, i.e. before Kubernetes 1.23.
+const ReasonAutoscalingUnavailable = "AutoscalingUnavailable"
+
+// reconcileAutoscaling creates, updates or deletes the HorizontalPodAutoscaler
+// of app. It returns the current HPA, or nil if autoscaling is disabled.
+func (r *AppReconciler) reconcileAutoscaling(ctx context.Context, app *ingressv1beta1.App) (*autoscalingv2.HorizontalPodAutoscaler, error) {
+	if err := r.updateAutoscalingCondition(ctx, app); err != nil {
+		return nil, err
+	}
+	// 集群不支持autoscaling/v2时按照未开启autoscaling处理
+	if r.autoscalingUnavailable {
+		return nil, nil
+	}
+	h := &autoscalingv2.HorizontalPodAutoscaler{}
+	err := r.Get(ctx, types.NamespacedName{Namespace: app.Namespace, Name: app.Name}, h)
+	if err != nil && !errors.IsNotFound(err) {
+		return nil, err
+	}
+	exists := err == nil
+
+	// 关闭了autoscaling时删除已有的HPA
+	if app.Spec.Autoscaling == nil {
+		if exists {
+			return nil, r.deleteOwned(ctx, app, h)
+		}
+		return nil, nil
+	}
+
+	hpa := utils.NewHorizontalPodAutoscaler(app)
+	if err := ctrl.SetControllerReference(app, hpa, r.Scheme); err != nil {
+		return nil, err
+	}
+	if !exists {
+		return hpa, r.createOwned(ctx, app, hpa)
+	}
+	return hpa, r.updateOwned(ctx, app, hpa, h)
+}
+
+// updateAutoscalingCondition reports in the status of app whether its
+// HorizontalPodAutoscaler is managed. The condition is removed when
+// autoscaling is disabled.
+func (r *AppReconciler) updateAutoscalingCondition(ctx context.Context, app *ingressv1beta1.App) error {
+	if app.Spec.Autoscaling == nil {
+		return r.removeCondition(ctx, app, ingressv1beta1.ConditionAutoscaling)
+	}
+
+	condition := metav1.Condition{
+		Type:               ingressv1beta1.ConditionAutoscaling,
+		Status:             metav1.ConditionTrue,
+		Reason:             "HorizontalPodAutoscalerManaged",
+		Message:            fmt.Sprintf("HorizontalPodAutoscaler %s scales the Deployment", app.Name),
+		ObservedGeneration: app.Generation,
+	}
+	eventType := corev1.EventTypeNormal
+	if r.autoscalingUnavailable {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = ReasonAutoscalingUnavailable
+		condition.Message = "The cluster does not serve autoscaling/v2, the Deployment runs a fixed number of replicas"
+		eventType = corev1.EventTypeWarning
+	}
+	return r.setCondition(ctx, app, condition, eventType)
+}
+
+// fixedReplicas sets the replicas of deployment when autoscaling is enabled
+// for app but no HPA is managed: the template leaves them to the HPA. The
+// Deployment runs the replicas of app, at least the minimum of autoscaling.
+func (r *AppReconciler) fixedReplicas(app *ingressv1beta1.App, deployment *appsv1.Deployment) {
+	if app.Spec.Autoscaling == nil || !r.autoscalingUnavailable {
+		return
+	}
+	replicas := int32(1)
+	if app.Spec.Replicas != nil {
+		replicas = *app.Spec.Replicas
+	}
+	if min := app.Spec.Autoscaling.MinReplicas; min != nil && *min > replicas {
+		replicas = *min
+	}
+	deployment.Spec.Replicas = &replicas
+}
+
+// updateReplicaStatus reports the current and desired replicas of app in its status.
+func (r *AppReconciler) updateReplicaStatus(ctx context.Context, app *ingressv1beta1.App, d *appsv1.Deployment, hpa *autoscalingv2.HorizontalPodAutoscaler) error {
+	desired := int32(1)
+	if app.Spec.Replicas != nil {
+		desired = *app.Spec.Replicas
+	}
+	// HPA启用时期望的副本数由HPA计算, HPA还没有计算出结果时使用deployment的副本数
+	if hpa != nil {
+		switch {
+		case hpa.Status.DesiredReplicas > 0:
+			desired = hpa.Status.DesiredReplicas
+		case d.Spec.Replicas != nil:
+			desired = *d.Spec.Replicas
+		}
+	} else if app.Spec.Autoscaling != nil && d.Spec.Replicas != nil {
+		// 集群不支持autoscaling/v2时使用fixedReplicas设置的副本数
+		desired = *d.Spec.Replicas
+	}
+	if app.Status.Replicas == d.Status.Replicas && app.Status.DesiredReplicas == desired {
+		return nil
+	}
+	app.Status.Replicas = d.Status.Replicas
+	app.Status.DesiredReplicas = desired
+	return r.Status().Update(ctx, app)
+}
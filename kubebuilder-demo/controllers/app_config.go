@@ -0,0 +1,118 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	ingressv1beta1 "github.com/kubebuilder-demo/api/v1beta1"
+	"github.com/kubebuilder-demo/controllers/utils"
+)
+
+// ConfigCache returns newCache restricting the cache of the ConfigMaps and
+// Secrets to the objects labeled ingressv1beta1.ConfigLabel, instead of
+// caching every ConfigMap and Secret of the cluster. The changes of the
+// unlabeled objects are not watched.
+func ConfigCache(newCache cache.NewCacheFunc) cache.NewCacheFunc {
+	return func(config *rest.Config, opts cache.Options) (cache.Cache, error) {
+		selector := cache.ObjectSelector{Label: labels.SelectorFromSet(labels.Set{ingressv1beta1.ConfigLabel: "true"})}
+		opts.SelectorsByObject = cache.SelectorsByObject{&corev1.ConfigMap{}: selector, &corev1.Secret{}: selector}
+		return newCache(config, opts)
+	}
+}
+
+// Reasons of the Config condition of an App.
+const (
+	ReasonConfigWatched    = "ConfigWatched"
+	ReasonConfigNotWatched = "ConfigNotWatched"
+)
+
+// configHash returns the hash of the data of the ConfigMaps and Secrets used
+// by app, or "" if it does not use any, and the names of the used objects
+// that are not labeled ingressv1beta1.ConfigLabel. Missing objects are hashed
+// as well, so the Pods restart once they are created.
+func (r *AppReconciler) configHash(ctx context.Context, app *ingressv1beta1.App) (string, []string, error) {
+	if len(app.Spec.Config) == 0 {
+		return "", nil, nil
+	}
+	var configMaps []*corev1.ConfigMap
+	var secrets []*corev1.Secret
+	var unwatched []string
+	for _, source := range app.Spec.Config {
+		if source.ConfigMap != "" {
+			cm := &corev1.ConfigMap{}
+			found, err := r.getConfig(ctx, types.NamespacedName{Namespace: app.Namespace, Name: source.ConfigMap}, cm)
+			if err != nil {
+				return "", nil, err
+			}
+			if !found {
+				cm = nil
+			} else if cm.Labels[ingressv1beta1.ConfigLabel] != "true" {
+				unwatched = append(unwatched, "ConfigMap "+cm.Name)
+			}
+			configMaps = append(configMaps, cm)
+		}
+		if source.Secret != "" {
+			secret := &corev1.Secret{}
+			found, err := r.getConfig(ctx, types.NamespacedName{Namespace: app.Namespace, Name: source.Secret}, secret)
+			if err != nil {
+				return "", nil, err
+			}
+			if !found {
+				secret = nil
+			} else if secret.Labels[ingressv1beta1.ConfigLabel] != "true" {
+				unwatched = append(unwatched, "Secret "+secret.Name)
+			}
+			secrets = append(secrets, secret)
+		}
+	}
+	return utils.ConfigHash(configMaps, secrets), unwatched, nil
+}
+
+// getConfig reads the ConfigMap or Secret obj used by an App. The objects not
+// labeled ingressv1beta1.ConfigLabel are not cached, they are read from the
+// API server. It returns false if obj does not exist.
+func (r *AppReconciler) getConfig(ctx context.Context, key types.NamespacedName, obj client.Object) (bool, error) {
+	err := r.Get(ctx, key, obj)
+	if errors.IsNotFound(err) && r.APIReader != nil {
+		err = r.APIReader.Get(ctx, key, obj)
+	}
+	if errors.IsNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// updateConfigCondition reports in the status of app whether changes of the
+// ConfigMaps and Secrets it uses restart its Pods, unwatched lists the objects
+// whose changes do not. The condition is removed when app uses none.
+func (r *AppReconciler) updateConfigCondition(ctx context.Context, app *ingressv1beta1.App, unwatched []string) error {
+	if len(app.Spec.Config) == 0 {
+		return r.removeCondition(ctx, app, ingressv1beta1.ConditionConfig)
+	}
+	condition := metav1.Condition{
+		Type:               ingressv1beta1.ConditionConfig,
+		Status:             metav1.ConditionTrue,
+		Reason:             ReasonConfigWatched,
+		Message:            "The Pods are restarted when the ConfigMaps and Secrets change",
+		ObservedGeneration: app.Generation,
+	}
+	eventType := corev1.EventTypeNormal
+	if len(unwatched) > 0 {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = ReasonConfigNotWatched
+		condition.Message = fmt.Sprintf("The Pods are not restarted when %s change, label them %s=true",
+			strings.Join(unwatched, ", "), ingressv1beta1.ConfigLabel)
+		eventType = corev1.EventTypeWarning
+	}
+	return r.setCondition(ctx, app, condition, eventType)
+}
@@ -18,22 +18,32 @@ package controllers
 
 import (
 	"context"
+	"time"
+
 	"github.com/kubebuilder-demo/controllers/utils"
-	_ "github.com/kubebuilder-demo/controllers/utils"
-	"google.golang.org/appengine/log"
+	"github.com/kubebuilder-demo/metrics"
 	v1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
 	corev1 "k8s.io/api/core/v1"
 	netv1 "k8s.io/api/networking/v1"
+	policyv1 "k8s.io/api/policy/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
-	_ "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/types"
-	_ "k8s.io/apimachinery/pkg/types"
-	_ "sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"k8s.io/client-go/tools/record"
 
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
-	_ "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	ingressv1beta1 "github.com/kubebuilder-demo/api/v1beta1"
 )
@@ -42,56 +52,131 @@ import (
 type AppReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
+
+	// Recorder records the Events of an App, e.g. failed rollouts.
+	Recorder record.EventRecorder
+
+	// APIReader reads the ConfigMaps and Secrets used by an App that are not
+	// cached, see ConfigCache. They are handled as missing when nil.
+	APIReader client.Reader
+
+	// CleanupHooks are run when an App is deleted, before its finalizer is
+	// removed. AppFinalizer is only added to the Apps when hooks are set.
+	CleanupHooks []CleanupHook
+
+	// MaxConcurrentReconciles is the number of Apps reconciled concurrently. Defaults to 1.
+	MaxConcurrentReconciles int
+
+	// NamespaceSelector restricts the Apps managed by the reconciler to the
+	// namespaces matching it. All namespaces watched by the cache are managed
+	// when nil.
+	NamespaceSelector labels.Selector
+
+	// WatchPredicates replaces the default predicates filtering the events of
+	// the watches of the controller, by watch name, e.g. WatchDeployment. The
+	// events of a watch set to an empty list are not filtered.
+	WatchPredicates map[string][]predicate.Predicate
+
+	// autoscalingUnavailable is set when the cluster does not serve the
+	// autoscaling/v2 HorizontalPodAutoscalers.
+	autoscalingUnavailable bool
 }
 
 //+kubebuilder:rbac:groups=ingress.baiding.tech,resources=apps,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=autoscaling,resources=horizontalpodautoscalers,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=policy,resources=poddisruptionbudgets,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=networking.k8s.io,resources=ingresses,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=networking.k8s.io,resources=ingressclasses,verbs=get;list;watch
 //+kubebuilder:rbac:groups="",resources=services,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=ingress.baiding.tech,resources=apps/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups="",resources=configmaps;secrets,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+//+kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
 //+kubebuilder:rbac:groups=ingress.baiding.tech,resources=apps/finalizers,verbs=update
 
-// Reconcile is part of the main kubernetes reconciliation loop which aims to
-// move the current state of the cluster closer to the desired state.
-// TODO(user): Modify the Reconcile function to compare the state specified by
-// the App object against the actual cluster state, and then
-// perform operations to make the cluster state reflect the state specified by
-// the user.
+// Reconcile moves the objects owned by the App named by req to the state
+// specified by the App, in this order:
+//
+//  1. scope: Apps in namespaces out of NamespaceSelector are left to other managers;
+//  2. finalizer: a deleted App runs its cleanup hooks, the others get AppFinalizer
+//     when cleanup hooks are registered;
+//  3. suspend: a suspended App only scales its Deployments as requested;
+//  4. dependencies: the workload waits until the Apps it depends on are Available;
+//  5. workload: the Deployments, revision history, HPA and PodDisruptionBudget;
+//  6. Service;
+//  7. Ingress, only handled when the Service is enabled.
 //
-// For more details, check Reconcile and its Result here:
-// - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.11.2/pkg/reconcile
+// The App is requeued while it waits for its dependencies or a rollout.
 func (r *AppReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
-	//todo
+	// 日志中带上App的namespace和name, 后续的处理都从ctx中获取logger
+	logger := logf.FromContext(ctx).WithValues("app", req.NamespacedName)
+	ctx = logf.IntoContext(ctx, logger)
+
 	// 先获取到App对象
 	app := &ingressv1beta1.App{}
 	err := r.Get(ctx, req.NamespacedName, app)
 	if err != nil {
+		if errors.IsNotFound(err) {
+			metrics.ForgetApp(req.NamespacedName)
+		}
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
-	// 1. 创建Deployment对象
-	deployment := utils.NewDeployment(app)
-	err = ctrl.SetControllerReference(app, deployment, r.Scheme)
+	// namespace不匹配NamespaceSelector的App由其他的manager处理
+	inScope, err := r.inScope(ctx, app.Namespace)
 	if err != nil {
 		return ctrl.Result{}, err
 	}
-	// 创建完成之后就查询是否存在deployment 如果不存在就创建, 如果存在就更新
-	d := &v1.Deployment{}
-	if err = r.Get(ctx, req.NamespacedName, d); err != nil {
-		// 如果不存在就创建
-		if errors.IsNotFound(err) {
-			if err := r.Create(ctx, deployment); err != nil {
-				log.Errorf(ctx, "Failed to create new Deployment: %v", err)
-				return ctrl.Result{}, err
-			}
-		}
-	} else {
-		// 如果存在就更新
-		if err = r.Update(ctx, deployment); err != nil {
+	if !inScope {
+		logger.V(1).Info("namespace of App is out of scope")
+		metrics.ForgetApp(req.NamespacedName)
+		return ctrl.Result{}, nil
+	}
+	// 正在删除的App执行清理逻辑, 不再处理其他资源
+	if !app.DeletionTimestamp.IsZero() {
+		metrics.ForgetApp(req.NamespacedName)
+		return r.finalize(ctx, app)
+	}
+	if _, err := r.ensureFinalizer(ctx, app); err != nil {
+		return ctrl.Result{}, err
+	}
+	// 暂停的App不再修改拥有的资源, 只在status中记录
+	if app.Spec.Suspend != nil {
+		logger.V(1).Info("App is suspended")
+		if err := r.reconcileSuspended(ctx, app); err != nil {
 			return ctrl.Result{}, err
 		}
+		return ctrl.Result{}, r.markReconcileHandled(ctx, app)
+	}
+	if err := r.updateSuspendedCondition(ctx, app); err != nil {
+		return ctrl.Result{}, err
+	}
+	// 1. Deployment的处理, 依赖的App都可用之后才创建或更新Deployment
+	waiting, err := r.waitForDependencies(ctx, app)
+	if err != nil {
+		return ctrl.Result{}, err
 	}
+	// 等待依赖时通过workqueue的限速器重新排队, 重试的间隔指数增长
+	result := ctrl.Result{Requeue: true}
+	if waiting {
+		logger.V(1).Info("waiting for the dependencies of App")
+	} else if result, err = r.reconcileWorkload(ctx, app); err != nil {
+		return ctrl.Result{}, err
+	}
+
 	// 2. Service的处理
+	start := time.Now()
 	service := utils.NewService(app)
+	legacy, err := r.legacyStable(ctx, app)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if legacy {
+		utils.UntrackLabels(service.Spec.Selector, app.Name)
+	}
+	if err := r.applyOverrides(app, service, app.Spec.Overrides.Service); err != nil {
+		return ctrl.Result{}, err
+	}
 	err = ctrl.SetControllerReference(app, service, r.Scheme)
 	if err != nil {
 		return ctrl.Result{}, err
@@ -100,8 +185,7 @@ func (r *AppReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.R
 	s := &corev1.Service{}
 	if err := r.Get(ctx, types.NamespacedName{Namespace: app.Namespace, Name: app.Name}, s); err != nil {
 		if errors.IsNotFound(err) && app.Spec.EnableService {
-			if err := r.Create(ctx, service); err != nil {
-				log.Errorf(ctx, "Failed to create new Service: %v", err)
+			if err := r.createOwned(ctx, app, service); err != nil {
 				return ctrl.Result{}, err
 			}
 		} else if !errors.IsNotFound(err) && app.Spec.EnableService {
@@ -110,28 +194,40 @@ func (r *AppReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.R
 		}
 	} else {
 		if app.Spec.EnableService {
-			if err := r.Update(ctx, service); err != nil {
+			if err := r.updateOwned(ctx, app, service, s); err != nil {
 				return ctrl.Result{}, err
 			}
 		} else {
-			if err := r.Delete(ctx, service); err != nil {
+			if err := r.deleteOwned(ctx, app, s); err != nil {
 				return ctrl.Result{}, err
 			}
 		}
 	}
+	metrics.ObserveReconcilePhase(metrics.PhaseService, start)
 
 	// 3. ingress的处理
 	//todo 使用admission webhook, 如果启动了ingress, 那么Service也启动
 	//todo 默认为false
 	if !app.Spec.EnableService {
-		return ctrl.Result{}, nil
+		return result, r.markReconcileHandled(ctx, app)
+	}
+	start = time.Now()
+	if err := r.updateIngressCondition(ctx, app); err != nil {
+		return ctrl.Result{}, err
 	}
 	ingress := utils.NewIngress(app)
+	if err := r.applyOverrides(app, ingress, app.Spec.Overrides.Ingress); err != nil {
+		return ctrl.Result{}, err
+	}
 	err = ctrl.SetControllerReference(app, ingress, r.Scheme)
 	if err != nil {
+		return ctrl.Result{}, err
+	}
+	// 查找指定的Ingress
+	i := &netv1.Ingress{}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: app.Namespace, Name: app.Name}, i); err != nil {
 		if errors.IsNotFound(err) && app.Spec.EnableIngress {
-			if err := r.Create(ctx, ingress); err != nil {
-				log.Errorf(ctx, "Failed to create new Ingress: %v", err)
+			if err := r.createOwned(ctx, app, ingress); err != nil {
 				return ctrl.Result{}, err
 			}
 		} else if !errors.IsNotFound(err) && app.Spec.EnableIngress {
@@ -140,25 +236,114 @@ func (r *AppReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.R
 		}
 	} else {
 		if app.Spec.EnableIngress {
-			err := r.Update(ctx, ingress)
+			err := r.updateOwned(ctx, app, ingress, i)
 			if err != nil {
 				return ctrl.Result{}, err
 			}
 		} else {
-			if err := r.Delete(ctx, ingress); err != nil {
+			if err := r.deleteOwned(ctx, app, i); err != nil {
 				return ctrl.Result{}, err
 			}
 		}
 	}
-	return ctrl.Result{}, nil
+	metrics.ObserveReconcilePhase(metrics.PhaseIngress, start)
+	return result, r.markReconcileHandled(ctx, app)
+}
+
+// reconcileWorkload rolls out the Deployment of app and reconciles the
+// objects scaling it: revision history, HPA and PodDisruptionBudget.
+func (r *AppReconciler) reconcileWorkload(ctx context.Context, app *ingressv1beta1.App) (ctrl.Result, error) {
+	// 按照发布策略发布新版本
+	start := time.Now()
+	deployment, requeueAfter, err := r.reconcileDeployments(ctx, app)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	metrics.SetAppReady(client.ObjectKeyFromObject(app), deploymentReady(deployment))
+	if err := r.updateAvailableCondition(ctx, app, deployment); err != nil {
+		return ctrl.Result{}, err
+	}
+	// 记录revision历史, 发布失败时回滚
+	if err := r.reconcileRevisionHistory(ctx, app, deployment); err != nil {
+		return ctrl.Result{}, err
+	}
+	// HPA的处理, 并在status中记录当前和期望的副本数
+	hpa, err := r.reconcileAutoscaling(ctx, app)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if err := r.updateReplicaStatus(ctx, app, deployment, hpa); err != nil {
+		return ctrl.Result{}, err
+	}
+	// PodDisruptionBudget的处理
+	if err := r.reconcilePodDisruptionBudget(ctx, app); err != nil {
+		return ctrl.Result{}, err
+	}
+	metrics.ObserveReconcilePhase(metrics.PhaseDeployment, start)
+	return ctrl.Result{RequeueAfter: requeueAfter}, nil
+}
+
+// setCondition sets condition in the status of app, recording an Event of
+// eventType when its status changes. The status is only updated when
+// condition changed.
+func (r *AppReconciler) setCondition(ctx context.Context, app *ingressv1beta1.App, condition metav1.Condition, eventType string) error {
+	existing := meta.FindStatusCondition(app.Status.Conditions, condition.Type)
+	if existing != nil && existing.Status == condition.Status && existing.Reason == condition.Reason &&
+		existing.Message == condition.Message && existing.ObservedGeneration == condition.ObservedGeneration {
+		return nil
+	}
+	if existing == nil || existing.Status != condition.Status {
+		r.Recorder.Event(app, eventType, condition.Reason, condition.Message)
+	}
+	meta.SetStatusCondition(&app.Status.Conditions, condition)
+	return r.Status().Update(ctx, app)
+}
+
+// removeCondition removes the condition of conditionType from the status of app.
+func (r *AppReconciler) removeCondition(ctx context.Context, app *ingressv1beta1.App, conditionType string) error {
+	if meta.FindStatusCondition(app.Status.Conditions, conditionType) == nil {
+		return nil
+	}
+	meta.RemoveStatusCondition(&app.Status.Conditions, conditionType)
+	return r.Status().Update(ctx, app)
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *AppReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
-		For(&ingressv1beta1.App{}).
-		Owns(&v1.Deployment{}).
-		Owns(&netv1.Ingress{}).
-		Owns(&corev1.Service{}).
-		Complete(r)
+	if err := setupAppIndexes(context.Background(), mgr); err != nil {
+		return err
+	}
+	// namespace不在管理范围内的App始终被过滤
+	appPredicates := append([]predicate.Predicate{predicate.NewPredicateFuncs(r.appInScope)}, r.watchPredicates(WatchApp)...)
+	// 没有autoscaling/v2的集群中无法watch HPA, 否则controller无法启动
+	_, err := mgr.GetRESTMapper().RESTMapping(schema.GroupKind{Group: autoscalingv2.GroupName, Kind: "HorizontalPodAutoscaler"}, autoscalingv2.SchemeGroupVersion.Version)
+	if err != nil && !meta.IsNoMatchError(err) {
+		return err
+	}
+	r.autoscalingUnavailable = err != nil
+	b := ctrl.NewControllerManagedBy(mgr).
+		For(&ingressv1beta1.App{}, builder.WithPredicates(appPredicates...)).
+		WithOptions(controller.Options{MaxConcurrentReconciles: r.MaxConcurrentReconciles}).
+		Owns(&v1.Deployment{}, builder.WithPredicates(r.watchPredicates(WatchDeployment)...)).
+		Owns(&policyv1.PodDisruptionBudget{}, builder.WithPredicates(r.watchPredicates(WatchPodDisruptionBudget)...)).
+		Owns(&netv1.Ingress{}, builder.WithPredicates(r.watchPredicates(WatchIngress)...)).
+		Owns(&corev1.Service{}, builder.WithPredicates(r.watchPredicates(WatchService)...)).
+		// 引用的对象变化时, 通过索引找到引用它的App
+		Watches(&source.Kind{Type: &corev1.ConfigMap{}}, handler.EnqueueRequestsFromMapFunc(r.appsReferencing(configMapIndexKey))).
+		Watches(&source.Kind{Type: &corev1.Secret{}}, handler.EnqueueRequestsFromMapFunc(r.appsReferencing(secretIndexKey))).
+		Watches(&source.Kind{Type: &netv1.IngressClass{}}, handler.EnqueueRequestsFromMapFunc(r.appsReferencing(ingressClassIndexKey))).
+		// 依赖的App变为可用时, 不用等到下一次重试
+		Watches(&source.Kind{Type: &ingressv1beta1.App{}}, handler.EnqueueRequestsFromMapFunc(r.appsReferencing(dependsOnIndexKey)),
+			builder.WithPredicates(r.watchPredicates(WatchDependency)...))
+	if r.autoscalingUnavailable {
+		ctrl.Log.WithName("app-controller").Info("autoscaling/v2 is not served by the cluster, Apps are not autoscaled")
+	} else {
+		b = b.Owns(&autoscalingv2.HorizontalPodAutoscaler{}, builder.WithPredicates(r.watchPredicates(WatchHorizontalPodAutoscaler)...))
+	}
+	// namespace的label变化后, 其中的App可能进入manager的管理范围
+	if r.NamespaceSelector != nil {
+		b = b.Watches(&source.Kind{Type: &corev1.Namespace{}}, handler.EnqueueRequestsFromMapFunc(r.appsInNamespace),
+			builder.WithPredicates(predicate.LabelChangedPredicate{}))
+	}
+	return b.Complete(r)
 }
@@ -0,0 +1,505 @@
+package controllers
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	netv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	ingressv1beta1 "github.com/kubebuilder-demo/api/v1beta1"
+	"github.com/kubebuilder-demo/controllers/utils"
+)
+
+var _ = Describe("App controller", func() {
+	newApp := func(name string) *ingressv1beta1.App {
+		return &ingressv1beta1.App{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec:       ingressv1beta1.AppSpec{Image: "nginx:1.21", EnableIngress: true},
+		}
+	}
+	key := func(name string) types.NamespacedName {
+		return types.NamespacedName{Namespace: "default", Name: name}
+	}
+	getApp := func(name string) *ingressv1beta1.App {
+		app := &ingressv1beta1.App{}
+		ExpectWithOffset(1, k8sClient.Get(ctx, key(name), app)).To(Succeed())
+		return app
+	}
+	// update retries the update of obj on conflicts with the updates of the reconciler.
+	update := func(name string, obj client.Object, mutate func()) {
+		EventuallyWithOffset(1, func() error {
+			if err := k8sClient.Get(ctx, key(name), obj); err != nil {
+				return err
+			}
+			mutate()
+			return k8sClient.Update(ctx, obj)
+		}).Should(Succeed())
+	}
+	updateApp := func(name string, mutate func(app *ingressv1beta1.App)) {
+		app := &ingressv1beta1.App{}
+		update(name, app, func() { mutate(app) })
+	}
+	// markAvailable sets the status the Deployment controller reports once
+	// all replicas of name are available, envtest does not run it.
+	markAvailable := func(name string) {
+		d := &appsv1.Deployment{}
+		EventuallyWithOffset(1, func() error {
+			if err := k8sClient.Get(ctx, key(name), d); err != nil {
+				return err
+			}
+			n := *d.Spec.Replicas
+			d.Status = appsv1.DeploymentStatus{ObservedGeneration: d.Generation, Replicas: n, UpdatedReplicas: n, ReadyReplicas: n, AvailableReplicas: n}
+			return k8sClient.Status().Update(ctx, d)
+		}).Should(Succeed())
+	}
+	condition := func(name, conditionType string) func() *metav1.Condition {
+		return func() *metav1.Condition {
+			return meta.FindStatusCondition(getApp(name).Status.Conditions, conditionType)
+		}
+	}
+	// image returns the image of the Deployment name, or "" if it does not exist.
+	image := func(name string) func() string {
+		return func() string {
+			d := &appsv1.Deployment{}
+			if err := k8sClient.Get(ctx, key(name), d); err != nil {
+				return ""
+			}
+			return d.Spec.Template.Spec.Containers[0].Image
+		}
+	}
+	replicas := func(name string) func() int32 {
+		return func() int32 {
+			d := &appsv1.Deployment{}
+			if err := k8sClient.Get(ctx, key(name), d); err != nil || d.Spec.Replicas == nil {
+				return 0
+			}
+			return *d.Spec.Replicas
+		}
+	}
+	// rollout returns the phase, step and weight of the rollout of the App name.
+	rollout := func(name string) func() ingressv1beta1.AppRolloutStatus {
+		return func() ingressv1beta1.AppRolloutStatus {
+			status := getApp(name).Status.Rollout
+			if status == nil {
+				return ingressv1beta1.AppRolloutStatus{}
+			}
+			return ingressv1beta1.AppRolloutStatus{Phase: status.Phase, CurrentStep: status.CurrentStep, CurrentWeight: status.CurrentWeight}
+		}
+	}
+	promote := func(name string) {
+		updateApp(name, func(app *ingressv1beta1.App) {
+			metav1.SetMetaDataAnnotation(&app.ObjectMeta, RolloutActionAnnotation, RolloutActionPromote)
+		})
+	}
+	notFound := func(name string, obj client.Object) func() bool {
+		return func() bool { return apierrors.IsNotFound(k8sClient.Get(ctx, key(name), obj)) }
+	}
+	resourceVersions := func(name string, objs ...client.Object) []string {
+		versions := make([]string, 0, len(objs))
+		for _, obj := range objs {
+			ExpectWithOffset(1, k8sClient.Get(ctx, key(name), obj)).To(Succeed())
+			versions = append(versions, obj.GetResourceVersion())
+		}
+		return versions
+	}
+
+	It("creates the objects of an App", func() {
+		Expect(k8sClient.Create(ctx, newApp("create"))).To(Succeed())
+		app := getApp("create")
+
+		d := &appsv1.Deployment{}
+		Eventually(func() error { return k8sClient.Get(ctx, key("create"), d) }).Should(Succeed())
+		Expect(metav1.IsControlledBy(d, app)).To(BeTrue())
+		Expect(d.Spec.Replicas).To(Equal(pointer.Int32(1)))
+		Expect(d.Spec.Template.Spec.Containers[0].Image).To(Equal("nginx:1.21"))
+
+		s := &corev1.Service{}
+		Eventually(func() error { return k8sClient.Get(ctx, key("create"), s) }).Should(Succeed())
+		Expect(metav1.IsControlledBy(s, app)).To(BeTrue())
+		Expect(s.Spec.Ports[0].Port).To(Equal(int32(8080)))
+
+		i := &netv1.Ingress{}
+		Eventually(func() error { return k8sClient.Get(ctx, key("create"), i) }).Should(Succeed())
+		Expect(metav1.IsControlledBy(i, app)).To(BeTrue())
+		Expect(i.Spec.Rules[0].Host).To(Equal("create." + ingressv1beta1.IngressDomain))
+	})
+
+	It("updates the Deployment when the App changes", func() {
+		Expect(k8sClient.Create(ctx, newApp("update"))).To(Succeed())
+		Eventually(func() error { return k8sClient.Get(ctx, key("update"), &appsv1.Deployment{}) }).Should(Succeed())
+
+		updateApp("update", func(app *ingressv1beta1.App) {
+			app.Spec.Image = "nginx:1.22"
+			app.Spec.Replicas = pointer.Int32(3)
+		})
+		Eventually(func() []interface{} {
+			d := &appsv1.Deployment{}
+			Expect(k8sClient.Get(ctx, key("update"), d)).To(Succeed())
+			return []interface{}{d.Spec.Template.Spec.Containers[0].Image, *d.Spec.Replicas}
+		}).Should(Equal([]interface{}{"nginx:1.22", int32(3)}))
+	})
+
+	It("deletes and recreates the Service and Ingress when they are toggled", func() {
+		Expect(k8sClient.Create(ctx, newApp("toggle"))).To(Succeed())
+		Eventually(func() error { return k8sClient.Get(ctx, key("toggle"), &netv1.Ingress{}) }).Should(Succeed())
+
+		updateApp("toggle", func(app *ingressv1beta1.App) { app.Spec.EnableIngress = false })
+		Eventually(func() bool {
+			return apierrors.IsNotFound(k8sClient.Get(ctx, key("toggle"), &netv1.Ingress{}))
+		}).Should(BeTrue())
+
+		updateApp("toggle", func(app *ingressv1beta1.App) { app.Spec.EnableService = false })
+		Eventually(func() bool {
+			return apierrors.IsNotFound(k8sClient.Get(ctx, key("toggle"), &corev1.Service{}))
+		}).Should(BeTrue())
+
+		updateApp("toggle", func(app *ingressv1beta1.App) {
+			app.Spec.EnableService = true
+			app.Spec.EnableIngress = true
+		})
+		Eventually(func() error { return k8sClient.Get(ctx, key("toggle"), &corev1.Service{}) }).Should(Succeed())
+		Eventually(func() error { return k8sClient.Get(ctx, key("toggle"), &netv1.Ingress{}) }).Should(Succeed())
+	})
+
+	It("corrects the drift of the objects of an App", func() {
+		Expect(k8sClient.Create(ctx, newApp("drift"))).To(Succeed())
+		s := &corev1.Service{}
+		Eventually(func() error { return k8sClient.Get(ctx, key("drift"), s) }).Should(Succeed())
+
+		update("drift", s, func() { s.Spec.Ports[0].Port = 9090 })
+		Eventually(func() int32 {
+			Expect(k8sClient.Get(ctx, key("drift"), s)).To(Succeed())
+			return s.Spec.Ports[0].Port
+		}).Should(Equal(int32(8080)))
+
+		d := &appsv1.Deployment{}
+		update("drift", d, func() { d.Spec.Template.Spec.Containers[0].Image = "nginx:latest" })
+		Eventually(func() string {
+			Expect(k8sClient.Get(ctx, key("drift"), d)).To(Succeed())
+			return d.Spec.Template.Spec.Containers[0].Image
+		}).Should(Equal("nginx:1.21"))
+	})
+
+	It("does not update the objects of an App that did not drift", func() {
+		Expect(k8sClient.Create(ctx, newApp("no-drift"))).To(Succeed())
+		Eventually(func() error { return k8sClient.Get(ctx, key("no-drift"), &netv1.Ingress{}) }).Should(Succeed())
+		Eventually(condition("no-drift", ingressv1beta1.ConditionAvailable)).ShouldNot(BeNil())
+		versions := resourceVersions("no-drift", &appsv1.Deployment{}, &corev1.Service{}, &netv1.Ingress{})
+
+		requestedAt := time.Now().UTC().Format(time.RFC3339Nano)
+		updateApp("no-drift", func(app *ingressv1beta1.App) {
+			metav1.SetMetaDataAnnotation(&app.ObjectMeta, ReconcileRequestedAtAnnotation, requestedAt)
+		})
+		Eventually(func() string { return getApp("no-drift").Status.LastHandledReconcileAt }).Should(Equal(requestedAt))
+		Expect(resourceVersions("no-drift", &appsv1.Deployment{}, &corev1.Service{}, &netv1.Ingress{})).To(Equal(versions))
+	})
+
+	It("keeps the Pod labels of Deployments created without the track label", func() {
+		labels := map[string]string{"app": "legacy"}
+		legacy := &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "legacy", Namespace: "default", Labels: labels},
+			Spec: appsv1.DeploymentSpec{
+				Selector: &metav1.LabelSelector{MatchLabels: labels},
+				Template: corev1.PodTemplateSpec{
+					ObjectMeta: metav1.ObjectMeta{Labels: labels},
+					Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "legacy", Image: "nginx:1.20"}}},
+				},
+			},
+		}
+		Expect(k8sClient.Create(ctx, legacy)).To(Succeed())
+		Expect(k8sClient.Create(ctx, newApp("legacy"))).To(Succeed())
+
+		d := &appsv1.Deployment{}
+		Eventually(func() string {
+			Expect(k8sClient.Get(ctx, key("legacy"), d)).To(Succeed())
+			return d.Spec.Template.Spec.Containers[0].Image
+		}).Should(Equal("nginx:1.21"))
+		Expect(d.Spec.Selector.MatchLabels).To(Equal(labels))
+		Expect(d.Spec.Template.Labels).To(Equal(labels))
+
+		s := &corev1.Service{}
+		Eventually(func() error { return k8sClient.Get(ctx, key("legacy"), s) }).Should(Succeed())
+		Expect(s.Spec.Selector).To(Equal(labels))
+	})
+
+	It("promotes the steps of a canary rollout", func() {
+		app := newApp("canary")
+		app.Spec.Replicas = pointer.Int32(4)
+		app.Spec.Strategy = ingressv1beta1.AppStrategy{Type: ingressv1beta1.CanaryStrategyType, Canary: &ingressv1beta1.AppCanaryStrategy{
+			Steps: []ingressv1beta1.AppCanaryStep{{Weight: 25}, {Weight: 50}},
+		}}
+		Expect(k8sClient.Create(ctx, app)).To(Succeed())
+		Eventually(image("canary")).Should(Equal("nginx:1.21"))
+		markAvailable("canary")
+		canaryWeight := func() string {
+			i := &netv1.Ingress{}
+			if err := k8sClient.Get(ctx, key("canary-canary"), i); err != nil {
+				return ""
+			}
+			return i.Annotations["nginx.ingress.kubernetes.io/canary-weight"]
+		}
+
+		// 第一步: 新版本运行25%的副本并接收25%的流量, 稳定版本保持不变
+		updateApp("canary", func(app *ingressv1beta1.App) { app.Spec.Image = "nginx:1.22" })
+		Eventually(image("canary-canary")).Should(Equal("nginx:1.22"))
+		Expect(replicas("canary-canary")()).To(Equal(int32(1)))
+		Eventually(canaryWeight).Should(Equal("25"))
+		Expect(image("canary")()).To(Equal("nginx:1.21"))
+		markAvailable("canary-canary")
+		Eventually(rollout("canary")).Should(Equal(ingressv1beta1.AppRolloutStatus{
+			Phase: ingressv1beta1.RolloutPaused, CurrentStep: 0, CurrentWeight: 25}))
+
+		promote("canary")
+		Eventually(replicas("canary-canary")).Should(Equal(int32(2)))
+		Eventually(canaryWeight).Should(Equal("50"))
+		markAvailable("canary-canary")
+		Eventually(rollout("canary")).Should(Equal(ingressv1beta1.AppRolloutStatus{
+			Phase: ingressv1beta1.RolloutPaused, CurrentStep: 1, CurrentWeight: 50}))
+		Expect(image("canary")()).To(Equal("nginx:1.21"))
+
+		// 最后一步之后更新稳定版本, 更新完成前新版本接收全部流量
+		promote("canary")
+		Eventually(image("canary")).Should(Equal("nginx:1.22"))
+		Eventually(canaryWeight).Should(Equal("100"))
+		Expect(rollout("canary")().Phase).To(Equal(ingressv1beta1.RolloutPromoting))
+		markAvailable("canary")
+		Eventually(rollout("canary")).Should(Equal(ingressv1beta1.AppRolloutStatus{
+			Phase: ingressv1beta1.RolloutCompleted, CurrentStep: 1, CurrentWeight: 100}))
+		Eventually(notFound("canary-canary", &appsv1.Deployment{})).Should(BeTrue())
+		Eventually(notFound("canary-canary", &corev1.Service{})).Should(BeTrue())
+		Eventually(notFound("canary-canary", &netv1.Ingress{})).Should(BeTrue())
+	})
+
+	It("switches the Service from the stable to the preview Deployment of a blue-green rollout", func() {
+		app := newApp("bluegreen")
+		app.Spec.Replicas = pointer.Int32(2)
+		app.Spec.Strategy = ingressv1beta1.AppStrategy{Type: ingressv1beta1.BlueGreenStrategyType}
+		Expect(k8sClient.Create(ctx, app)).To(Succeed())
+		Eventually(image("bluegreen")).Should(Equal("nginx:1.21"))
+		markAvailable("bluegreen")
+		selectedTrack := func(name string) func() string {
+			return func() string {
+				s := &corev1.Service{}
+				if err := k8sClient.Get(ctx, key(name), s); err != nil {
+					return "<none>"
+				}
+				return s.Spec.Selector[utils.TrackLabel]
+			}
+		}
+
+		// promote之前新版本只能通过preview Service访问
+		updateApp("bluegreen", func(app *ingressv1beta1.App) { app.Spec.Image = "nginx:1.22" })
+		Eventually(image("bluegreen-preview")).Should(Equal("nginx:1.22"))
+		Expect(replicas("bluegreen-preview")()).To(Equal(int32(2)))
+		Eventually(selectedTrack("bluegreen")).Should(Equal(utils.TrackStable))
+		Eventually(selectedTrack("bluegreen-preview")).Should(Equal(utils.TrackPreview))
+		markAvailable("bluegreen-preview")
+		Eventually(rollout("bluegreen")).Should(Equal(ingressv1beta1.AppRolloutStatus{Phase: ingressv1beta1.RolloutPaused}))
+		Expect(image("bluegreen")()).To(Equal("nginx:1.21"))
+
+		// promote之后Service切换到新版本, 直到稳定版本更新完成
+		promote("bluegreen")
+		Eventually(selectedTrack("bluegreen")).Should(Equal(utils.TrackPreview))
+		Eventually(image("bluegreen")).Should(Equal("nginx:1.22"))
+		markAvailable("bluegreen")
+		Eventually(rollout("bluegreen")).Should(Equal(ingressv1beta1.AppRolloutStatus{Phase: ingressv1beta1.RolloutCompleted}))
+		Eventually(selectedTrack("bluegreen")).Should(BeEmpty())
+		Eventually(notFound("bluegreen-preview", &appsv1.Deployment{})).Should(BeTrue())
+		Eventually(notFound("bluegreen-preview", &corev1.Service{})).Should(BeTrue())
+	})
+
+	It("restarts the Pods when the labeled ConfigMaps change", func() {
+		configHash := func() string {
+			d := &appsv1.Deployment{}
+			if err := k8sClient.Get(ctx, key("config"), d); err != nil {
+				return ""
+			}
+			return d.Spec.Template.Annotations[utils.ConfigHashAnnotation]
+		}
+		labeled := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "config-labeled", Namespace: "default", Labels: map[string]string{ingressv1beta1.ConfigLabel: "true"}},
+			Data:       map[string]string{"level": "info"},
+		}
+		unlabeled := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "config-unlabeled", Namespace: "default"},
+			Data:       map[string]string{"level": "info"},
+		}
+		Expect(k8sClient.Create(ctx, labeled)).To(Succeed())
+		Expect(k8sClient.Create(ctx, unlabeled)).To(Succeed())
+		app := newApp("config")
+		app.Spec.Config = []ingressv1beta1.AppConfigSource{{ConfigMap: "config-unlabeled"}}
+		Expect(k8sClient.Create(ctx, app)).To(Succeed())
+
+		configReason := func() string {
+			if c := condition("config", ingressv1beta1.ConditionConfig)(); c != nil {
+				return c.Reason
+			}
+			return ""
+		}
+
+		// 没有label的ConfigMap不在缓存中, 直接从API server读取, 但是不会监听它的变化
+		Eventually(configHash).Should(Equal(utils.ConfigHash([]*corev1.ConfigMap{unlabeled}, nil)))
+		Eventually(configReason).Should(Equal(ReasonConfigNotWatched))
+
+		updateApp("config", func(app *ingressv1beta1.App) {
+			app.Spec.Config = []ingressv1beta1.AppConfigSource{{ConfigMap: "config-labeled"}}
+		})
+		Eventually(configHash).Should(Equal(utils.ConfigHash([]*corev1.ConfigMap{labeled}, nil)))
+		Eventually(configReason).Should(Equal(ReasonConfigWatched))
+
+		update("config-labeled", labeled, func() { labeled.Data["level"] = "debug" })
+		Eventually(configHash).Should(Equal(utils.ConfigHash([]*corev1.ConfigMap{labeled}, nil)))
+	})
+
+	It("reports whether the App is available", func() {
+		Expect(k8sClient.Create(ctx, newApp("available"))).To(Succeed())
+		Eventually(condition("available", ingressv1beta1.ConditionAvailable)).Should(And(
+			Not(BeNil()),
+			WithTransform(func(c *metav1.Condition) string { return c.Reason }, Equal(ReasonDeploymentNotAvailable)),
+		))
+
+		markAvailable("available")
+		Eventually(func() metav1.ConditionStatus {
+			if c := condition("available", ingressv1beta1.ConditionAvailable)(); c != nil {
+				return c.Status
+			}
+			return ""
+		}).Should(Equal(metav1.ConditionTrue))
+		Expect(getApp("available").Status.Replicas).To(Equal(int32(1)))
+	})
+
+	It("holds back the Deployment until the Apps it depends on are available", func() {
+		app := newApp("dependent")
+		app.Spec.DependsOn = []string{"dependency"}
+		Expect(k8sClient.Create(ctx, app)).To(Succeed())
+		waiting := func() string {
+			if c := condition("dependent", ingressv1beta1.ConditionWaitingForDependencies)(); c != nil {
+				return string(c.Status) + "/" + c.Reason
+			}
+			return ""
+		}
+		Eventually(waiting).Should(Equal("True/" + ReasonDependenciesNotAvailable))
+		Expect(notFound("dependent", &appsv1.Deployment{})()).To(BeTrue())
+
+		// 依赖的App存在但还不可用时继续等待
+		Expect(k8sClient.Create(ctx, newApp("dependency"))).To(Succeed())
+		Eventually(image("dependency")).Should(Equal("nginx:1.21"))
+		Consistently(notFound("dependent", &appsv1.Deployment{}), time.Second).Should(BeTrue())
+
+		markAvailable("dependency")
+		Eventually(image("dependent")).Should(Equal("nginx:1.21"))
+		Eventually(waiting).Should(Equal("False/" + ReasonDependenciesAvailable))
+	})
+
+	// envtest的API server不支持autoscaling/v2, 对应Kubernetes 1.23之前的集群
+	It("runs a fixed number of replicas when autoscaling/v2 is unavailable", func() {
+		app := newApp("no-autoscaling")
+		app.Spec.Autoscaling = &ingressv1beta1.AppAutoscaling{MinReplicas: pointer.Int32(2), MaxReplicas: 5}
+		Expect(k8sClient.Create(ctx, app)).To(Succeed())
+
+		Eventually(func() *int32 {
+			d := &appsv1.Deployment{}
+			if err := k8sClient.Get(ctx, key("no-autoscaling"), d); err != nil {
+				return nil
+			}
+			return d.Spec.Replicas
+		}).Should(Equal(pointer.Int32(2)))
+		Eventually(condition("no-autoscaling", ingressv1beta1.ConditionAutoscaling)).Should(And(
+			Not(BeNil()),
+			WithTransform(func(c *metav1.Condition) string { return c.Reason }, Equal(ReasonAutoscalingUnavailable)),
+		))
+
+		// 再次reconcile时不重复记录Event
+		requestedAt := time.Now().UTC().Format(time.RFC3339Nano)
+		updateApp("no-autoscaling", func(app *ingressv1beta1.App) {
+			metav1.SetMetaDataAnnotation(&app.ObjectMeta, ReconcileRequestedAtAnnotation, requestedAt)
+		})
+		Eventually(func() string { return getApp("no-autoscaling").Status.LastHandledReconcileAt }).Should(Equal(requestedAt))
+		autoscalingEvents := func() int32 {
+			events := &corev1.EventList{}
+			Expect(k8sClient.List(ctx, events, client.InNamespace("default"),
+				client.MatchingFields{"involvedObject.name": "no-autoscaling", "reason": ReasonAutoscalingUnavailable})).To(Succeed())
+			var count int32
+			for _, e := range events.Items {
+				count += e.Count
+			}
+			return count
+		}
+		Eventually(autoscalingEvents).Should(Equal(int32(1)))
+		Consistently(autoscalingEvents, time.Second).Should(Equal(int32(1)))
+	})
+
+	It("scales a suspended App to zero", func() {
+		Expect(k8sClient.Create(ctx, newApp("suspend"))).To(Succeed())
+		Eventually(func() error { return k8sClient.Get(ctx, key("suspend"), &appsv1.Deployment{}) }).Should(Succeed())
+
+		updateApp("suspend", func(app *ingressv1beta1.App) {
+			app.Spec.Suspend = &ingressv1beta1.AppSuspend{ScaleToZero: true}
+		})
+		Eventually(func() int32 {
+			d := &appsv1.Deployment{}
+			Expect(k8sClient.Get(ctx, key("suspend"), d)).To(Succeed())
+			return *d.Spec.Replicas
+		}).Should(BeZero())
+		Eventually(condition("suspend", ingressv1beta1.ConditionSuspended)).Should(And(
+			Not(BeNil()),
+			WithTransform(func(c *metav1.Condition) metav1.ConditionStatus { return c.Status }, Equal(metav1.ConditionTrue)),
+		))
+	})
+
+	It("runs the cleanup hooks of a deleted App", func() {
+		Expect(k8sClient.Create(ctx, newApp("delete"))).To(Succeed())
+		Eventually(func() []string { return getApp("delete").Finalizers }).Should(ContainElement(AppFinalizer))
+
+		Expect(k8sClient.Delete(ctx, getApp("delete"))).To(Succeed())
+		Eventually(func() bool {
+			return apierrors.IsNotFound(k8sClient.Get(ctx, key("delete"), &ingressv1beta1.App{}))
+		}).Should(BeTrue())
+		_, ok := cleanedUp.Load("delete")
+		Expect(ok).To(BeTrue())
+	})
+
+	Context("webhooks", func() {
+		It("rejects invalid Apps", func() {
+			app := newApp("invalid")
+			app.Spec.Replicas = pointer.Int32(-1)
+			err := k8sClient.Create(ctx, app)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue(), "unexpected error: %v", err)
+		})
+
+		It("rejects invalid updates", func() {
+			Expect(k8sClient.Create(ctx, newApp("invalid-update"))).To(Succeed())
+			var err error
+			Eventually(func() bool {
+				app := getApp("invalid-update")
+				app.Spec.Replicas = pointer.Int32(ingressv1beta1.MaxReplicas + 1)
+				err = k8sClient.Update(ctx, app)
+				return apierrors.IsConflict(err)
+			}).Should(BeFalse())
+			Expect(apierrors.IsInvalid(err)).To(BeTrue(), "unexpected error: %v", err)
+		})
+
+		It("rejects the deletion of protected Apps", func() {
+			app := newApp("protected")
+			app.Labels = map[string]string{ingressv1beta1.ProtectedLabel: "true"}
+			Expect(k8sClient.Create(ctx, app)).To(Succeed())
+
+			err := k8sClient.Delete(ctx, app)
+			Expect(apierrors.IsForbidden(err)).To(BeTrue(), "unexpected error: %v", err)
+
+			updateApp("protected", func(app *ingressv1beta1.App) {
+				metav1.SetMetaDataAnnotation(&app.ObjectMeta, ingressv1beta1.ConfirmDeleteAnnotation, "protected")
+			})
+			Expect(k8sClient.Delete(ctx, getApp("protected"))).To(Succeed())
+		})
+	})
+})
@@ -0,0 +1,80 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	ingressv1beta1 "github.com/kubebuilder-demo/api/v1beta1"
+)
+
+// Reasons of the Available and WaitingForDependencies conditions of an App.
+const (
+	ReasonDeploymentAvailable      = "DeploymentAvailable"
+	ReasonDeploymentNotAvailable   = "DeploymentNotAvailable"
+	ReasonDependenciesAvailable    = "DependenciesAvailable"
+	ReasonDependenciesNotAvailable = "DependenciesNotAvailable"
+)
+
+// waitForDependencies reports in the status of app whether the Apps it
+// depends on are Available, and returns true while some of them are not.
+func (r *AppReconciler) waitForDependencies(ctx context.Context, app *ingressv1beta1.App) (bool, error) {
+	var waiting []string
+	for _, name := range app.Spec.DependsOn {
+		dep := &ingressv1beta1.App{}
+		if err := r.Get(ctx, types.NamespacedName{Namespace: app.Namespace, Name: name}, dep); err != nil {
+			if !errors.IsNotFound(err) {
+				return false, err
+			}
+			waiting = append(waiting, name)
+			continue
+		}
+		if !meta.IsStatusConditionTrue(dep.Status.Conditions, ingressv1beta1.ConditionAvailable) {
+			waiting = append(waiting, name)
+		}
+	}
+
+	condition := metav1.Condition{
+		Type:               ingressv1beta1.ConditionWaitingForDependencies,
+		Status:             metav1.ConditionTrue,
+		Reason:             ReasonDependenciesNotAvailable,
+		Message:            fmt.Sprintf("Waiting for Apps %s to be available", strings.Join(waiting, ", ")),
+		ObservedGeneration: app.Generation,
+	}
+	existing := meta.FindStatusCondition(app.Status.Conditions, ingressv1beta1.ConditionWaitingForDependencies)
+	// 没有等待过依赖的App不添加这个condition
+	if len(waiting) == 0 {
+		if existing == nil {
+			return false, nil
+		}
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = ReasonDependenciesAvailable
+		condition.Message = "All the Apps the App depends on are available"
+	}
+	return len(waiting) > 0, r.setCondition(ctx, app, condition, corev1.EventTypeNormal)
+}
+
+// updateAvailableCondition reports in the status of app whether all replicas
+// of its stable Deployment d are available.
+func (r *AppReconciler) updateAvailableCondition(ctx context.Context, app *ingressv1beta1.App, d *appsv1.Deployment) error {
+	condition := metav1.Condition{
+		Type:               ingressv1beta1.ConditionAvailable,
+		Status:             metav1.ConditionTrue,
+		Reason:             ReasonDeploymentAvailable,
+		Message:            fmt.Sprintf("All replicas of Deployment %s are available", d.Name),
+		ObservedGeneration: app.Generation,
+	}
+	if !deploymentReady(d) {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = ReasonDeploymentNotAvailable
+		condition.Message = fmt.Sprintf("Deployment %s has %d available replicas", d.Name, d.Status.AvailableReplicas)
+	}
+	return r.setCondition(ctx, app, condition, corev1.EventTypeNormal)
+}
@@ -0,0 +1,137 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"reflect"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/rand"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// desiredHashAnnotation records on an object owned by an App the hash of its
+// desired state when it was last updated, so that the fields removed from
+// the desired state are detected.
+const desiredHashAnnotation = "ingress.baiding.tech/desired-hash"
+
+// setDesiredHash records the hash of the desired state obj in its annotations.
+func setDesiredHash(obj client.Object) error {
+	fields, err := managedFields(obj)
+	if err != nil {
+		return err
+	}
+	// map按照key排序序列化, 相同的状态得到相同的hash
+	b, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	hasher := fnv.New32a()
+	hasher.Write(b)
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[desiredHashAnnotation] = rand.SafeEncodeString(fmt.Sprint(hasher.Sum32()))
+	obj.SetAnnotations(annotations)
+	return nil
+}
+
+// drifted reports whether live has to be updated to desired, whose hash has
+// been set by setDesiredHash: either desired changed since live was last
+// updated, or a field set in desired has another value in live. The fields
+// only set in live, e.g. defaulted by the API server, are ignored.
+func drifted(desired, live client.Object) (bool, error) {
+	if desired.GetAnnotations()[desiredHashAnnotation] != live.GetAnnotations()[desiredHashAnnotation] {
+		return true, nil
+	}
+	desiredFields, err := managedFields(desired)
+	if err != nil {
+		return false, err
+	}
+	liveFields, err := managedFields(live)
+	if err != nil {
+		return false, err
+	}
+	return !isSubset(desiredFields, liveFields), nil
+}
+
+// managedFields returns the fields of obj set by the controller: its labels,
+// annotations and owner references, and all the fields out of metadata but
+// the status.
+func managedFields(obj client.Object) (map[string]interface{}, error) {
+	fields, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return nil, err
+	}
+	delete(fields, "apiVersion")
+	delete(fields, "kind")
+	delete(fields, "status")
+	metadata := map[string]interface{}{}
+	if m, ok := fields["metadata"].(map[string]interface{}); ok {
+		for _, key := range []string{"labels", "annotations", "ownerReferences"} {
+			if value, ok := m[key]; ok {
+				metadata[key] = value
+			}
+		}
+	}
+	if annotations, ok := metadata["annotations"].(map[string]interface{}); ok {
+		delete(annotations, desiredHashAnnotation)
+	}
+	fields["metadata"] = metadata
+	return fields, nil
+}
+
+// isSubset reports whether every field set in desired has the same value in
+// live. Lists must have the same length, their items are compared in order.
+func isSubset(desired, live interface{}) bool {
+	switch d := desired.(type) {
+	case map[string]interface{}:
+		l, ok := live.(map[string]interface{})
+		if !ok {
+			return len(d) == 0 && live == nil
+		}
+		for key, value := range d {
+			liveValue, ok := l[key]
+			if !ok {
+				// 期望为空值的字段在live中不存在时也认为相同
+				if isEmpty(value) {
+					continue
+				}
+				return false
+			}
+			if !isSubset(value, liveValue) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		l, ok := live.([]interface{})
+		if !ok || len(l) != len(d) {
+			return len(d) == 0 && live == nil
+		}
+		for i := range d {
+			if !isSubset(d[i], l[i]) {
+				return false
+			}
+		}
+		return true
+	default:
+		return reflect.DeepEqual(desired, live)
+	}
+}
+
+// isEmpty reports whether value is the zero value of its type, e.g. an empty map.
+func isEmpty(value interface{}) bool {
+	if value == nil {
+		return true
+	}
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Map, reflect.Slice:
+		return v.Len() == 0
+	default:
+		return v.IsZero()
+	}
+}
@@ -0,0 +1,49 @@
+package controllers
+
+import (
+	"context"
+
+	policyv1 "k8s.io/api/policy/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	ingressv1beta1 "github.com/kubebuilder-demo/api/v1beta1"
+	"github.com/kubebuilder-demo/controllers/utils"
+)
+
+// reconcilePodDisruptionBudget creates, updates or deletes the
+// PodDisruptionBudget of app.
+func (r *AppReconciler) reconcilePodDisruptionBudget(ctx context.Context, app *ingressv1beta1.App) error {
+	p := &policyv1.PodDisruptionBudget{}
+	err := r.Get(ctx, types.NamespacedName{Namespace: app.Namespace, Name: app.Name}, p)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	exists := err == nil
+
+	// 没有配置PodDisruptionBudget时删除已有的PDB
+	if app.Spec.PodDisruptionBudget == nil {
+		if exists {
+			return r.deleteOwned(ctx, app, p)
+		}
+		return nil
+	}
+
+	pdb := utils.NewPodDisruptionBudget(app)
+	// 与稳定版本deployment的selector保持一致, 不选择发布中的新版本Pod
+	legacy, err := r.legacyStable(ctx, app)
+	if err != nil {
+		return err
+	}
+	if legacy {
+		utils.UntrackLabels(pdb.Spec.Selector.MatchLabels, app.Name)
+	}
+	if err := ctrl.SetControllerReference(app, pdb, r.Scheme); err != nil {
+		return err
+	}
+	if !exists {
+		return r.createOwned(ctx, app, pdb)
+	}
+	return r.updateOwned(ctx, app, pdb, p)
+}
@@ -0,0 +1,123 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	netv1 "k8s.io/api/networking/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	ingressv1beta1 "github.com/kubebuilder-demo/api/v1beta1"
+	"github.com/kubebuilder-demo/metrics"
+)
+
+// Reasons of the Events recorded for the resources owned by an App.
+const (
+	ReasonCreated = "Created"
+	ReasonUpdated = "Updated"
+	ReasonDeleted = "Deleted"
+	ReasonFailed  = "Failed"
+)
+
+// createOwned creates obj owned by app and records the result as an Event of app.
+func (r *AppReconciler) createOwned(ctx context.Context, app *ingressv1beta1.App, obj client.Object) error {
+	// 记录期望状态的hash, 下一次reconcile时不会当作漂移再更新一次
+	if err := setDesiredHash(obj); err != nil {
+		return err
+	}
+	err := r.Create(ctx, obj)
+	r.recordResult(ctx, app, obj, "create", ReasonCreated, err)
+	return err
+}
+
+// updateOwned updates live, owned by app, to obj. The update is skipped when
+// live did not drift from obj, obj is then set to a copy of live. Either way
+// obj holds the current state of the object on success: callers read its
+// status from obj, e.g. the available replicas of a Deployment. An Event is
+// only recorded when the update changed live or failed.
+func (r *AppReconciler) updateOwned(ctx context.Context, app *ingressv1beta1.App, obj, live client.Object) error {
+	if err := setDesiredHash(obj); err != nil {
+		return err
+	}
+	drift, err := drifted(obj, live)
+	if err != nil {
+		return err
+	}
+	if !drift {
+		metrics.RecordOwnedResourceUpdate(r.kindOf(obj), false)
+		return copyLive(obj, live)
+	}
+	obj.SetResourceVersion(live.GetResourceVersion())
+	err = r.Update(ctx, obj)
+	if err == nil {
+		metrics.RecordOwnedResourceUpdate(r.kindOf(obj), true)
+		if obj.GetResourceVersion() == live.GetResourceVersion() {
+			return nil
+		}
+	}
+	r.recordResult(ctx, app, obj, "update", ReasonUpdated, err)
+	return err
+}
+
+// copyLive deep copies live into obj of the same type, so that the callers of
+// updateOwned read the current status of the object from obj.
+func copyLive(obj, live client.Object) error {
+	switch o := obj.(type) {
+	case *appsv1.Deployment:
+		live.(*appsv1.Deployment).DeepCopyInto(o)
+	case *corev1.Service:
+		live.(*corev1.Service).DeepCopyInto(o)
+	case *netv1.Ingress:
+		live.(*netv1.Ingress).DeepCopyInto(o)
+	case *autoscalingv2.HorizontalPodAutoscaler:
+		live.(*autoscalingv2.HorizontalPodAutoscaler).DeepCopyInto(o)
+	case *policyv1.PodDisruptionBudget:
+		live.(*policyv1.PodDisruptionBudget).DeepCopyInto(o)
+	default:
+		return fmt.Errorf("unable to copy the live state of %T", obj)
+	}
+	return nil
+}
+
+// deleteOwned deletes obj owned by app and records the result as an Event of app.
+func (r *AppReconciler) deleteOwned(ctx context.Context, app *ingressv1beta1.App, obj client.Object) error {
+	err := r.Delete(ctx, obj)
+	if errors.IsNotFound(err) {
+		return err
+	}
+	r.recordResult(ctx, app, obj, "delete", ReasonDeleted, err)
+	return err
+}
+
+// recordResult logs the result of a change to obj, records it as an Event of
+// app and counts it in the owned resource metrics.
+func (r *AppReconciler) recordResult(ctx context.Context, app *ingressv1beta1.App, obj client.Object, verb, reason string, err error) {
+	logger := logf.FromContext(ctx)
+	kind := r.kindOf(obj)
+	metrics.RecordOwnedResourceOperation(kind, verb, err)
+	if err != nil {
+		logger.Error(err, "unable to "+verb+" "+kind, "name", obj.GetName())
+		// 冲突会在下一次reconcile时重试, 不记录Event
+		if !errors.IsConflict(err) {
+			r.Recorder.Eventf(app, corev1.EventTypeWarning, ReasonFailed, "Failed to %s %s %s: %v", verb, kind, obj.GetName(), err)
+		}
+		return
+	}
+	logger.Info(reason+" "+kind, "name", obj.GetName())
+	r.Recorder.Eventf(app, corev1.EventTypeNormal, reason, "%s %s %s", reason, kind, obj.GetName())
+}
+
+// kindOf returns the kind of obj, looked up in the scheme.
+func (r *AppReconciler) kindOf(obj client.Object) string {
+	if gvk, err := apiutil.GVKForObject(obj, r.Scheme); err == nil {
+		return gvk.Kind
+	}
+	return obj.GetObjectKind().GroupVersionKind().Kind
+}
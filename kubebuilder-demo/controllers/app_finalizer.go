@@ -0,0 +1,146 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	ingressv1beta1 "github.com/kubebuilder-demo/api/v1beta1"
+)
+
+const (
+	// AppFinalizer keeps a deleted App around until all cleanup hooks succeeded.
+	// It is only added to the Apps when AppReconciler.CleanupHooks is set: the
+	// manager does not register any hook, so it is only used by the programs
+	// embedding AppReconciler with their own hooks.
+	AppFinalizer = "ingress.baiding.tech/cleanup"
+
+	// CleanupTimeoutAnnotation limits how long the cleanup hooks of a deleted
+	// App are retried, e.g. "10m". The finalizer is removed once the timeout
+	// has passed since the deletion, even if some hooks still fail.
+	CleanupTimeoutAnnotation = "cleanup.baiding.tech/timeout"
+
+	// ForceCleanupAnnotation set to "true" removes the finalizer without
+	// running the remaining cleanup hooks.
+	ForceCleanupAnnotation = "cleanup.baiding.tech/force"
+
+	// cleanupRetryPeriod is the delay before failed cleanup hooks are retried.
+	cleanupRetryPeriod = 30 * time.Second
+)
+
+// CleanupHook releases a side effect of an App that owner references cannot
+// garbage collect, e.g. DNS records or resources in other namespaces.
+// Cleanup is retried until it succeeds, so it must be idempotent.
+type CleanupHook interface {
+	Name() string
+	Cleanup(ctx context.Context, app *ingressv1beta1.App) error
+}
+
+// CleanupHookFunc adapts a function to a CleanupHook.
+type CleanupHookFunc struct {
+	HookName string
+	Fn       func(ctx context.Context, app *ingressv1beta1.App) error
+}
+
+func (h CleanupHookFunc) Name() string { return h.HookName }
+
+func (h CleanupHookFunc) Cleanup(ctx context.Context, app *ingressv1beta1.App) error {
+	return h.Fn(ctx, app)
+}
+
+// ensureFinalizer adds AppFinalizer to app when cleanup hooks are registered.
+// It returns true if app was updated.
+func (r *AppReconciler) ensureFinalizer(ctx context.Context, app *ingressv1beta1.App) (bool, error) {
+	if len(r.CleanupHooks) == 0 || controllerutil.ContainsFinalizer(app, AppFinalizer) {
+		return false, nil
+	}
+	controllerutil.AddFinalizer(app, AppFinalizer)
+	return true, r.Update(ctx, app)
+}
+
+// finalize runs the cleanup hooks of a deleted App and removes AppFinalizer
+// once all of them succeeded, the cleanup timed out or was forced.
+func (r *AppReconciler) finalize(ctx context.Context, app *ingressv1beta1.App) (ctrl.Result, error) {
+	logger := logf.FromContext(ctx)
+	if !controllerutil.ContainsFinalizer(app, AppFinalizer) {
+		return ctrl.Result{}, nil
+	}
+
+	if app.Annotations[ForceCleanupAnnotation] == "true" {
+		logger.Info("cleanup forced by annotation, skipping cleanup hooks", "annotation", ForceCleanupAnnotation)
+		return ctrl.Result{}, r.removeFinalizer(ctx, app)
+	}
+	timeout, err := cleanupTimeout(app)
+	if err != nil {
+		// 超时时间配置错误时不会超时, 只记录日志
+		logger.Error(err, "ignoring invalid cleanup timeout")
+	}
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = app.DeletionTimestamp.Add(timeout)
+		if time.Now().After(deadline) {
+			logger.Info("cleanup timed out, removing finalizer", "timeout", timeout, "pending", app.Status.PendingCleanupHooks)
+			return ctrl.Result{}, r.removeFinalizer(ctx, app)
+		}
+	}
+
+	// 依次执行所有的清理钩子, 记录失败的钩子
+	var pending []string
+	var errs []error
+	for _, hook := range r.CleanupHooks {
+		if err := hook.Cleanup(ctx, app); err != nil {
+			pending = append(pending, hook.Name())
+			errs = append(errs, fmt.Errorf("cleanup hook %s: %w", hook.Name(), err))
+		}
+	}
+
+	if len(pending) == 0 {
+		logger.Info("cleanup hooks succeeded, removing finalizer")
+		return ctrl.Result{}, r.removeFinalizer(ctx, app)
+	}
+
+	app.Status.PendingCleanupHooks = pending
+	meta.SetStatusCondition(&app.Status.Conditions, metav1.Condition{
+		Type:               ingressv1beta1.ConditionCleanup,
+		Status:             metav1.ConditionFalse,
+		Reason:             "CleanupPending",
+		Message:            fmt.Sprintf("waiting for cleanup hooks %s", strings.Join(pending, ", ")),
+		ObservedGeneration: app.Generation,
+	})
+	if err := r.Status().Update(ctx, app); err != nil {
+		return ctrl.Result{}, err
+	}
+	logger.Error(utilerrors.NewAggregate(errs), "cleanup hooks failed", "pending", pending)
+	// 定期重试, 但不晚于超时时间
+	retry := cleanupRetryPeriod
+	if !deadline.IsZero() && time.Until(deadline) < retry {
+		retry = time.Until(deadline)
+	}
+	return ctrl.Result{RequeueAfter: retry}, nil
+}
+
+func (r *AppReconciler) removeFinalizer(ctx context.Context, app *ingressv1beta1.App) error {
+	controllerutil.RemoveFinalizer(app, AppFinalizer)
+	return r.Update(ctx, app)
+}
+
+// cleanupTimeout returns the duration set by CleanupTimeoutAnnotation, or 0 if unset.
+func cleanupTimeout(app *ingressv1beta1.App) (time.Duration, error) {
+	value, ok := app.Annotations[CleanupTimeoutAnnotation]
+	if !ok {
+		return 0, nil
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s annotation %q: %w", CleanupTimeoutAnnotation, value, err)
+	}
+	return timeout, nil
+}
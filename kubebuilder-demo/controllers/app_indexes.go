@@ -0,0 +1,87 @@
+package controllers
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	ingressv1beta1 "github.com/kubebuilder-demo/api/v1beta1"
+)
+
+// Field indexes of Apps by the names of the objects they reference.
+const (
+	configMapIndexKey    = ".spec.config.configMap"
+	secretIndexKey       = ".spec.config.secret"
+	ingressClassIndexKey = ".spec.ingress.ingressClassName"
+	dependsOnIndexKey    = ".spec.dependsOn"
+)
+
+// appIndexes extract the names of the referenced objects from an App, by index key.
+var appIndexes = map[string]func(app *ingressv1beta1.App) []string{
+	configMapIndexKey: func(app *ingressv1beta1.App) []string {
+		var names []string
+		for _, source := range app.Spec.Config {
+			if source.ConfigMap != "" {
+				names = append(names, source.ConfigMap)
+			}
+		}
+		return names
+	},
+	secretIndexKey: func(app *ingressv1beta1.App) []string {
+		var names []string
+		for _, source := range app.Spec.Config {
+			if source.Secret != "" {
+				names = append(names, source.Secret)
+			}
+		}
+		return names
+	},
+	ingressClassIndexKey: func(app *ingressv1beta1.App) []string {
+		if !app.Spec.EnableIngress || app.Spec.Ingress.IngressClassName == nil {
+			return nil
+		}
+		return []string{*app.Spec.Ingress.IngressClassName}
+	},
+	dependsOnIndexKey: func(app *ingressv1beta1.App) []string {
+		return app.Spec.DependsOn
+	},
+}
+
+// setupAppIndexes registers appIndexes on the cache of mgr.
+func setupAppIndexes(ctx context.Context, mgr ctrl.Manager) error {
+	for key, extract := range appIndexes {
+		extract := extract
+		if err := mgr.GetFieldIndexer().IndexField(ctx, &ingressv1beta1.App{}, key, func(obj client.Object) []string {
+			return extract(obj.(*ingressv1beta1.App))
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// appsReferencing returns a map function enqueuing the Apps that reference
+// an object, looked up by the field index indexKey. Apps only reference
+// namespaced objects in their own namespace.
+func (r *AppReconciler) appsReferencing(indexKey string) handler.MapFunc {
+	return func(obj client.Object) []reconcile.Request {
+		opts := []client.ListOption{client.MatchingFields{indexKey: obj.GetName()}}
+		if obj.GetNamespace() != "" {
+			opts = append(opts, client.InNamespace(obj.GetNamespace()))
+		}
+		apps := &ingressv1beta1.AppList{}
+		if err := r.List(context.Background(), apps, opts...); err != nil {
+			ctrl.Log.WithName("app-controller").Error(err, "unable to list Apps referencing object", "index", indexKey, "namespace", obj.GetNamespace(), "name", obj.GetName())
+			return nil
+		}
+		requests := make([]reconcile.Request, 0, len(apps.Items))
+		for _, app := range apps.Items {
+			requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: app.Namespace, Name: app.Name}})
+		}
+		return requests
+	}
+}
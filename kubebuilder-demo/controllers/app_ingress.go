@@ -0,0 +1,51 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	netv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	ingressv1beta1 "github.com/kubebuilder-demo/api/v1beta1"
+)
+
+// ReasonIngressClassNotFound is the reason of the Ingress condition and Event
+// of an App whose IngressClass does not exist.
+const ReasonIngressClassNotFound = "IngressClassNotFound"
+
+// updateIngressCondition reports in the status of app whether the
+// IngressClass referenced by its Ingress exists.
+func (r *AppReconciler) updateIngressCondition(ctx context.Context, app *ingressv1beta1.App) error {
+	condition := metav1.Condition{
+		Type:               ingressv1beta1.ConditionIngress,
+		Status:             metav1.ConditionTrue,
+		Reason:             "IngressClassFound",
+		ObservedGeneration: app.Generation,
+	}
+	// 没有启用ingress或者使用默认的IngressClass时不检查
+	if !app.Spec.EnableIngress || app.Spec.Ingress.IngressClassName == nil {
+		return r.removeCondition(ctx, app, ingressv1beta1.ConditionIngress)
+	}
+
+	className := *app.Spec.Ingress.IngressClassName
+	if err := r.Get(ctx, types.NamespacedName{Name: className}, &netv1.IngressClass{}); err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = ReasonIngressClassNotFound
+		condition.Message = fmt.Sprintf("IngressClass %s does not exist", className)
+	} else {
+		condition.Message = fmt.Sprintf("IngressClass %s exists", className)
+	}
+
+	eventType := corev1.EventTypeNormal
+	if condition.Status == metav1.ConditionFalse {
+		eventType = corev1.EventTypeWarning
+	}
+	return r.setCondition(ctx, app, condition, eventType)
+}
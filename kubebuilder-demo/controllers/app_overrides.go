@@ -0,0 +1,22 @@
+package controllers
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	ingressv1beta1 "github.com/kubebuilder-demo/api/v1beta1"
+)
+
+// ReasonInvalidOverride is the reason of the Event recorded when the
+// overrides of an App do not apply to an object generated for it.
+const ReasonInvalidOverride = "InvalidOverride"
+
+// applyOverrides applies patches to obj generated for app. The webhook
+// rejects patches that do not apply, a failure is recorded as an Event of app.
+func (r *AppReconciler) applyOverrides(app *ingressv1beta1.App, obj client.Object, patches []ingressv1beta1.AppPatch) error {
+	if err := ingressv1beta1.ApplyPatches(obj, patches); err != nil {
+		r.Recorder.Eventf(app, corev1.EventTypeWarning, ReasonInvalidOverride, "Unable to apply the overrides to %s %s: %v", r.kindOf(obj), obj.GetName(), err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package controllers
+
+import (
+	"reflect"
+
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	ingressv1beta1 "github.com/kubebuilder-demo/api/v1beta1"
+)
+
+// Names of the watches of the App controller, see AppReconciler.WatchPredicates.
+const (
+	WatchApp                     = "App"
+	WatchDependency              = "Dependency"
+	WatchDeployment              = "Deployment"
+	WatchHorizontalPodAutoscaler = "HorizontalPodAutoscaler"
+	WatchPodDisruptionBudget     = "PodDisruptionBudget"
+	WatchIngress                 = "Ingress"
+	WatchService                 = "Service"
+)
+
+// forceReconcileAnnotations are the annotations of an App read by the
+// reconciler. Their changes do not change the generation of the App.
+var forceReconcileAnnotations = []string{
+	ReconcileRequestedAtAnnotation,
+	RolloutActionAnnotation,
+	ForceCleanupAnnotation,
+	CleanupTimeoutAnnotation,
+}
+
+// defaultWatchPredicates returns the predicates of the watches whose
+// predicates are not set in WatchPredicates.
+func defaultWatchPredicates() map[string][]predicate.Predicate {
+	return map[string][]predicate.Predicate{
+		// App的status由reconciler更新, 只处理spec和annotation的变化
+		WatchApp: {predicate.Or(predicate.GenerationChangedPredicate{}, AnnotationsChangedPredicate(forceReconcileAnnotations...))},
+		// 依赖的App只关心是否可用
+		WatchDependency:              {ConditionChangedPredicate(ingressv1beta1.ConditionAvailable)},
+		WatchDeployment:              {predicate.Or(ManagedFieldsChangedPredicate(), StatusChangedPredicate(deploymentStatus))},
+		WatchHorizontalPodAutoscaler: {predicate.Or(ManagedFieldsChangedPredicate(), StatusChangedPredicate(hpaStatus))},
+		WatchPodDisruptionBudget:     {ManagedFieldsChangedPredicate()},
+		WatchIngress:                 {ManagedFieldsChangedPredicate()},
+		WatchService:                 {ManagedFieldsChangedPredicate()},
+	}
+}
+
+// watchPredicates returns the predicates of watch: the predicates set in
+// WatchPredicates if any, its default predicates otherwise.
+func (r *AppReconciler) watchPredicates(watch string) []predicate.Predicate {
+	if predicates, ok := r.WatchPredicates[watch]; ok {
+		return predicates
+	}
+	return defaultWatchPredicates()[watch]
+}
+
+// AnnotationsChangedPredicate returns a predicate passing the update events
+// changing the value of one of keys in the annotations of the object.
+func AnnotationsChangedPredicate(keys ...string) predicate.Predicate {
+	return predicate.Funcs{
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			if e.ObjectOld == nil || e.ObjectNew == nil {
+				return false
+			}
+			oldAnnotations, newAnnotations := e.ObjectOld.GetAnnotations(), e.ObjectNew.GetAnnotations()
+			for _, key := range keys {
+				oldValue, oldOK := oldAnnotations[key]
+				newValue, newOK := newAnnotations[key]
+				if oldOK != newOK || oldValue != newValue {
+					return true
+				}
+			}
+			return false
+		},
+	}
+}
+
+// ManagedFieldsChangedPredicate returns a predicate passing the update events
+// changing the fields set by the controller on an owned object, i.e. its
+// labels, annotations, owner references and spec. Objects without a
+// generation, e.g. Services, are covered as well.
+func ManagedFieldsChangedPredicate() predicate.Predicate {
+	return predicate.Funcs{
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			if e.ObjectOld == nil || e.ObjectNew == nil {
+				return false
+			}
+			oldFields, err := managedFields(e.ObjectOld)
+			if err != nil {
+				return true
+			}
+			newFields, err := managedFields(e.ObjectNew)
+			if err != nil {
+				return true
+			}
+			return !reflect.DeepEqual(oldFields, newFields)
+		},
+	}
+}
+
+// StatusChangedPredicate returns a predicate passing the update events
+// changing the part of the status of an object returned by status.
+func StatusChangedPredicate(status func(obj client.Object) interface{}) predicate.Predicate {
+	return predicate.Funcs{
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			if e.ObjectOld == nil || e.ObjectNew == nil {
+				return false
+			}
+			return !reflect.DeepEqual(status(e.ObjectOld), status(e.ObjectNew))
+		},
+	}
+}
+
+// ConditionChangedPredicate returns a predicate passing the update events
+// changing the status of the condition of type conditionType of an App.
+func ConditionChangedPredicate(conditionType string) predicate.Predicate {
+	return StatusChangedPredicate(func(obj client.Object) interface{} {
+		app, ok := obj.(*ingressv1beta1.App)
+		if !ok {
+			return nil
+		}
+		if c := meta.FindStatusCondition(app.Status.Conditions, conditionType); c != nil {
+			return c.Status
+		}
+		return nil
+	})
+}
+
+// deploymentStatus returns the status fields of a Deployment read by the
+// reconciler: the replicas and the progress of the rollout.
+func deploymentStatus(obj client.Object) interface{} {
+	d, ok := obj.(*appsv1.Deployment)
+	if !ok {
+		return nil
+	}
+	status := struct {
+		ObservedGeneration                                          int64
+		Replicas, UpdatedReplicas, ReadyReplicas, AvailableReplicas int32
+		Progressing                                                 *appsv1.DeploymentCondition
+	}{
+		ObservedGeneration: d.Status.ObservedGeneration,
+		Replicas:           d.Status.Replicas,
+		UpdatedReplicas:    d.Status.UpdatedReplicas,
+		ReadyReplicas:      d.Status.ReadyReplicas,
+		AvailableReplicas:  d.Status.AvailableReplicas,
+	}
+	for i := range d.Status.Conditions {
+		if c := d.Status.Conditions[i]; c.Type == appsv1.DeploymentProgressing {
+			// 只比较状态和原因, 忽略心跳时间
+			status.Progressing = &appsv1.DeploymentCondition{Type: c.Type, Status: c.Status, Reason: c.Reason}
+		}
+	}
+	return status
+}
+
+// hpaStatus returns the status field of a HorizontalPodAutoscaler read by the
+// reconciler: the desired replicas.
+func hpaStatus(obj client.Object) interface{} {
+	h, ok := obj.(*autoscalingv2.HorizontalPodAutoscaler)
+	if !ok {
+		return nil
+	}
+	return h.Status.DesiredReplicas
+}
@@ -0,0 +1,114 @@
+package controllers
+
+import (
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	ingressv1beta1 "github.com/kubebuilder-demo/api/v1beta1"
+)
+
+var _ = Describe("App controller predicates", func() {
+	passes := func(watch string, old, new client.Object) bool {
+		r := &AppReconciler{}
+		for _, p := range r.watchPredicates(watch) {
+			if !p.Update(event.UpdateEvent{ObjectOld: old, ObjectNew: new}) {
+				return false
+			}
+		}
+		return true
+	}
+
+	newApp := func() *ingressv1beta1.App {
+		return &ingressv1beta1.App{
+			ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default", Generation: 1, ResourceVersion: "1"},
+			Spec:       ingressv1beta1.AppSpec{Image: "nginx:1.20"},
+		}
+	}
+	newDeployment := func() *appsv1.Deployment {
+		return &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default", Generation: 1, ResourceVersion: "1",
+				Labels: map[string]string{"app": "app"}},
+			Status: appsv1.DeploymentStatus{ObservedGeneration: 1, Replicas: 1, AvailableReplicas: 1,
+				Conditions: []appsv1.DeploymentCondition{{Type: appsv1.DeploymentProgressing, Status: corev1.ConditionTrue, Reason: "NewReplicaSetAvailable"}}},
+		}
+	}
+
+	table.DescribeTable("filters the update events of Apps",
+		func(update func(app *ingressv1beta1.App), expected bool) {
+			old := newApp()
+			app := old.DeepCopy()
+			app.ResourceVersion = "2"
+			update(app)
+			Expect(passes(WatchApp, old, app)).To(Equal(expected))
+		},
+		table.Entry("spec changed", func(app *ingressv1beta1.App) { app.Spec.Image = "nginx:1.21"; app.Generation++ }, true),
+		table.Entry("reconcile requested", func(app *ingressv1beta1.App) {
+			metav1.SetMetaDataAnnotation(&app.ObjectMeta, ReconcileRequestedAtAnnotation, "2022-06-01T00:00:00Z")
+		}, true),
+		table.Entry("rollout action", func(app *ingressv1beta1.App) {
+			metav1.SetMetaDataAnnotation(&app.ObjectMeta, RolloutActionAnnotation, RolloutActionPromote)
+		}, true),
+		table.Entry("status changed", func(app *ingressv1beta1.App) { app.Status.Replicas = 1 }, false),
+		table.Entry("other annotation", func(app *ingressv1beta1.App) {
+			metav1.SetMetaDataAnnotation(&app.ObjectMeta, "example.com/owner", "team-a")
+		}, false),
+	)
+
+	table.DescribeTable("filters the update events of Deployments",
+		func(update func(d *appsv1.Deployment), expected bool) {
+			old := newDeployment()
+			d := old.DeepCopy()
+			d.ResourceVersion = "2"
+			update(d)
+			Expect(passes(WatchDeployment, old, d)).To(Equal(expected))
+		},
+		table.Entry("spec changed", func(d *appsv1.Deployment) { d.Spec.Paused = true }, true),
+		table.Entry("label removed", func(d *appsv1.Deployment) { d.Labels = nil }, true),
+		table.Entry("available replicas changed", func(d *appsv1.Deployment) { d.Status.AvailableReplicas = 0 }, true),
+		table.Entry("progress deadline exceeded", func(d *appsv1.Deployment) {
+			d.Status.Conditions[0].Status = corev1.ConditionFalse
+			d.Status.Conditions[0].Reason = "ProgressDeadlineExceeded"
+		}, true),
+		table.Entry("condition heartbeat", func(d *appsv1.Deployment) {
+			d.Status.Conditions[0].LastUpdateTime = metav1.Now()
+		}, false),
+		table.Entry("unavailable replicas changed", func(d *appsv1.Deployment) { d.Status.UnavailableReplicas = 1 }, false),
+		table.Entry("managed fields changed", func(d *appsv1.Deployment) {
+			d.ManagedFields = []metav1.ManagedFieldsEntry{{Manager: "kube-controller-manager"}}
+		}, false),
+	)
+
+	It("filters the update events of Services without a generation", func() {
+		old := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default", ResourceVersion: "1"},
+			Spec: corev1.ServiceSpec{Ports: []corev1.ServicePort{{Port: 8080}}}}
+		s := old.DeepCopy()
+		s.ResourceVersion = "2"
+		s.Status.LoadBalancer.Ingress = []corev1.LoadBalancerIngress{{IP: "10.0.0.1"}}
+		Expect(passes(WatchService, old, s)).To(BeFalse())
+		s.Spec.Ports[0].Port = 80
+		Expect(passes(WatchService, old, s)).To(BeTrue())
+	})
+
+	It("only passes the dependencies becoming available or unavailable", func() {
+		old := newApp()
+		app := old.DeepCopy()
+		app.Status.Replicas = 1
+		Expect(passes(WatchDependency, old, app)).To(BeFalse())
+		meta.SetStatusCondition(&app.Status.Conditions, metav1.Condition{Type: ingressv1beta1.ConditionAvailable, Status: metav1.ConditionTrue, Reason: ReasonDeploymentAvailable})
+		Expect(passes(WatchDependency, old, app)).To(BeTrue())
+	})
+
+	It("does not filter the watches configured without predicates", func() {
+		r := &AppReconciler{WatchPredicates: map[string][]predicate.Predicate{WatchDeployment: nil}}
+		Expect(r.watchPredicates(WatchDeployment)).To(BeEmpty())
+		Expect(r.watchPredicates(WatchService)).NotTo(BeEmpty())
+	})
+})
@@ -0,0 +1,111 @@
+package controllers
+
+import (
+	"context"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	ingressv1beta1 "github.com/kubebuilder-demo/api/v1beta1"
+)
+
+// Reasons of the Events recorded for the revisions of an App.
+const (
+	ReasonRevisionCreated          = "RevisionCreated"
+	ReasonRevisionHealthy          = "RevisionHealthy"
+	ReasonProgressDeadlineExceeded = "ProgressDeadlineExceeded"
+	ReasonRolledBack               = "RolledBack"
+	ReasonRollbackUnavailable      = "RollbackUnavailable"
+)
+
+// reconcileRevisionHistory records the revision of the stable Deployment d
+// in the status of app. When the revision exceeds its progress deadline and
+// automatic rollback is enabled, the image of app is set back to the image
+// of the last healthy revision.
+func (r *AppReconciler) reconcileRevisionHistory(ctx context.Context, app *ingressv1beta1.App, d *appsv1.Deployment) error {
+	revision := d.Annotations[revisionAnnotation]
+	if revision == "" {
+		return nil
+	}
+	image := ""
+	if containers := d.Spec.Template.Spec.Containers; len(containers) > 0 {
+		image = containers[0].Image
+	}
+
+	// 最新的revision放在最后, 重新发布的revision移动到最后
+	history := make([]ingressv1beta1.AppRevision, 0, len(app.Status.History)+1)
+	for _, rev := range app.Status.History {
+		if rev.Revision != revision {
+			history = append(history, rev)
+		}
+	}
+	if n := len(app.Status.History); n > 0 && app.Status.History[n-1].Revision == revision {
+		history = append(history, app.Status.History[n-1])
+	} else {
+		history = append(history, ingressv1beta1.AppRevision{
+			Revision:   revision,
+			Image:      image,
+			Phase:      ingressv1beta1.RevisionProgressing,
+			DeployedAt: metav1.Now(),
+		})
+		r.Recorder.Eventf(app, corev1.EventTypeNormal, ReasonRevisionCreated, "Rolling out revision %s with image %s", revision, image)
+	}
+	limit := int32(ingressv1beta1.DefaultHistoryLimit)
+	if app.Spec.Rollback.HistoryLimit != nil {
+		limit = *app.Spec.Rollback.HistoryLimit
+	}
+	if len(history) > int(limit) {
+		history = history[len(history)-int(limit):]
+	}
+
+	current := &history[len(history)-1]
+	rollback := false
+	switch {
+	case current.Phase != ingressv1beta1.RevisionHealthy && deploymentReady(d):
+		current.Phase = ingressv1beta1.RevisionHealthy
+		r.Recorder.Eventf(app, corev1.EventTypeNormal, ReasonRevisionHealthy, "Revision %s with image %s is available", revision, image)
+	case current.Phase == ingressv1beta1.RevisionProgressing && progressDeadlineExceeded(d):
+		current.Phase = ingressv1beta1.RevisionFailed
+		r.Recorder.Eventf(app, corev1.EventTypeWarning, ReasonProgressDeadlineExceeded,
+			"Revision %s with image %s did not become available within the progress deadline of Deployment %s", revision, image, d.Name)
+		rollback = app.Spec.Rollback.Auto
+	}
+
+	if !apiequality.Semantic.DeepEqual(app.Status.History, history) {
+		app.Status.History = history
+		if err := r.Status().Update(ctx, app); err != nil {
+			return err
+		}
+	}
+	if !rollback {
+		return nil
+	}
+
+	// 回滚到最近一个健康的revision的镜像
+	for i := len(history) - 2; i >= 0; i-- {
+		if healthy := history[i]; healthy.Phase == ingressv1beta1.RevisionHealthy {
+			r.Recorder.Eventf(app, corev1.EventTypeWarning, ReasonRolledBack,
+				"Rolled back image from %s to %s of healthy revision %s", app.Spec.Image, healthy.Image, healthy.Revision)
+			app.Spec.Image = healthy.Image
+			return r.Update(ctx, app)
+		}
+	}
+	r.Recorder.Event(app, corev1.EventTypeWarning, ReasonRollbackUnavailable, "No healthy revision to roll back to")
+	return nil
+}
+
+// progressDeadlineExceeded returns true if the rollout of the current Pod
+// template of d exceeded its progress deadline.
+func progressDeadlineExceeded(d *appsv1.Deployment) bool {
+	if d.Status.ObservedGeneration < d.Generation {
+		return false
+	}
+	for _, c := range d.Status.Conditions {
+		if c.Type == appsv1.DeploymentProgressing {
+			return c.Status == corev1.ConditionFalse && c.Reason == "ProgressDeadlineExceeded"
+		}
+	}
+	return false
+}
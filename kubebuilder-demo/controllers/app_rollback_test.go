@@ -0,0 +1,191 @@
+package controllers
+
+import (
+	"context"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	ingressv1beta1 "github.com/kubebuilder-demo/api/v1beta1"
+)
+
+var _ = Describe("App rollback", func() {
+	const (
+		progressing = "Progressing"
+		ready       = "Ready"
+		failed      = "ProgressDeadlineExceeded"
+	)
+
+	revision := func(name, image string, phase ingressv1beta1.RevisionPhase) ingressv1beta1.AppRevision {
+		return ingressv1beta1.AppRevision{Revision: name, Image: image, Phase: phase}
+	}
+	// 只比较revision, 镜像和阶段, 忽略发布时间
+	summary := func(history []ingressv1beta1.AppRevision) []string {
+		var revisions []string
+		for _, rev := range history {
+			revisions = append(revisions, rev.Revision+" "+rev.Image+" "+string(rev.Phase))
+		}
+		return revisions
+	}
+	newDeployment := func(name, image, state string) *appsv1.Deployment {
+		d := &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "rollback", Namespace: "default", Generation: 1,
+				Annotations: map[string]string{revisionAnnotation: name}},
+			Spec: appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{Name: "rollback", Image: image}},
+			}}},
+			Status: appsv1.DeploymentStatus{ObservedGeneration: 1, Replicas: 1, UpdatedReplicas: 1},
+		}
+		switch state {
+		case ready:
+			d.Status.AvailableReplicas = 1
+		case failed:
+			d.Status.Conditions = []appsv1.DeploymentCondition{{Type: appsv1.DeploymentProgressing,
+				Status: corev1.ConditionFalse, Reason: "ProgressDeadlineExceeded"}}
+		default:
+			d.Status.Conditions = []appsv1.DeploymentCondition{{Type: appsv1.DeploymentProgressing,
+				Status: corev1.ConditionTrue, Reason: "ReplicaSetUpdated"}}
+		}
+		return d
+	}
+
+	table.DescribeTable("records the revisions and rolls back failed rollouts",
+		func(history []ingressv1beta1.AppRevision, rollback ingressv1beta1.AppRollback, d *appsv1.Deployment,
+			expectedHistory []ingressv1beta1.AppRevision, expectedImage string, expectedEvents []string) {
+			ctx := context.Background()
+			app := &ingressv1beta1.App{
+				ObjectMeta: metav1.ObjectMeta{Name: "rollback", Namespace: "default"},
+				Spec:       ingressv1beta1.AppSpec{Image: d.Spec.Template.Spec.Containers[0].Image, Rollback: rollback},
+				Status:     ingressv1beta1.AppStatus{History: history},
+			}
+			recorder := record.NewFakeRecorder(10)
+			r := &AppReconciler{
+				Client:   fake.NewClientBuilder().WithScheme(k8sClient.Scheme()).WithObjects(app).Build(),
+				Scheme:   k8sClient.Scheme(),
+				Recorder: recorder,
+			}
+
+			Expect(r.reconcileRevisionHistory(ctx, app, d)).To(Succeed())
+
+			stored := &ingressv1beta1.App{}
+			Expect(r.Get(ctx, client.ObjectKeyFromObject(app), stored)).To(Succeed())
+			Expect(summary(stored.Status.History)).To(Equal(summary(expectedHistory)))
+			Expect(stored.Spec.Image).To(Equal(expectedImage))
+			var reasons []string
+			for len(recorder.Events) > 0 {
+				reasons = append(reasons, strings.Fields(<-recorder.Events)[1])
+			}
+			Expect(reasons).To(Equal(expectedEvents))
+		},
+		table.Entry("new revision",
+			[]ingressv1beta1.AppRevision{revision("a", "nginx:1.20", ingressv1beta1.RevisionHealthy)},
+			ingressv1beta1.AppRollback{},
+			newDeployment("b", "nginx:1.21", progressing),
+			[]ingressv1beta1.AppRevision{
+				revision("a", "nginx:1.20", ingressv1beta1.RevisionHealthy),
+				revision("b", "nginx:1.21", ingressv1beta1.RevisionProgressing),
+			},
+			"nginx:1.21", []string{ReasonRevisionCreated}),
+		table.Entry("redeployed revision moved to the end",
+			[]ingressv1beta1.AppRevision{
+				revision("a", "nginx:1.20", ingressv1beta1.RevisionHealthy),
+				revision("b", "nginx:1.21", ingressv1beta1.RevisionHealthy),
+			},
+			ingressv1beta1.AppRollback{},
+			newDeployment("a", "nginx:1.20", ready),
+			[]ingressv1beta1.AppRevision{
+				revision("b", "nginx:1.21", ingressv1beta1.RevisionHealthy),
+				revision("a", "nginx:1.20", ingressv1beta1.RevisionHealthy),
+			},
+			"nginx:1.20", []string{ReasonRevisionCreated, ReasonRevisionHealthy}),
+		table.Entry("history trimmed to the limit",
+			[]ingressv1beta1.AppRevision{
+				revision("a", "nginx:1.20", ingressv1beta1.RevisionHealthy),
+				revision("b", "nginx:1.21", ingressv1beta1.RevisionHealthy),
+			},
+			ingressv1beta1.AppRollback{HistoryLimit: pointer.Int32(2)},
+			newDeployment("c", "nginx:1.22", progressing),
+			[]ingressv1beta1.AppRevision{
+				revision("b", "nginx:1.21", ingressv1beta1.RevisionHealthy),
+				revision("c", "nginx:1.22", ingressv1beta1.RevisionProgressing),
+			},
+			"nginx:1.22", []string{ReasonRevisionCreated}),
+		table.Entry("available revision",
+			[]ingressv1beta1.AppRevision{
+				revision("a", "nginx:1.20", ingressv1beta1.RevisionHealthy),
+				revision("b", "nginx:1.21", ingressv1beta1.RevisionProgressing),
+			},
+			ingressv1beta1.AppRollback{},
+			newDeployment("b", "nginx:1.21", ready),
+			[]ingressv1beta1.AppRevision{
+				revision("a", "nginx:1.20", ingressv1beta1.RevisionHealthy),
+				revision("b", "nginx:1.21", ingressv1beta1.RevisionHealthy),
+			},
+			"nginx:1.21", []string{ReasonRevisionHealthy}),
+		table.Entry("progress deadline exceeded without automatic rollback",
+			[]ingressv1beta1.AppRevision{
+				revision("a", "nginx:1.20", ingressv1beta1.RevisionHealthy),
+				revision("b", "nginx:1.21", ingressv1beta1.RevisionProgressing),
+			},
+			ingressv1beta1.AppRollback{},
+			newDeployment("b", "nginx:1.21", failed),
+			[]ingressv1beta1.AppRevision{
+				revision("a", "nginx:1.20", ingressv1beta1.RevisionHealthy),
+				revision("b", "nginx:1.21", ingressv1beta1.RevisionFailed),
+			},
+			"nginx:1.21", []string{ReasonProgressDeadlineExceeded}),
+		table.Entry("automatic rollback to the last healthy image",
+			[]ingressv1beta1.AppRevision{
+				revision("a", "nginx:1.20", ingressv1beta1.RevisionHealthy),
+				revision("b", "nginx:1.21", ingressv1beta1.RevisionFailed),
+				revision("c", "nginx:1.22", ingressv1beta1.RevisionProgressing),
+			},
+			ingressv1beta1.AppRollback{Auto: true},
+			newDeployment("c", "nginx:1.22", failed),
+			[]ingressv1beta1.AppRevision{
+				revision("a", "nginx:1.20", ingressv1beta1.RevisionHealthy),
+				revision("b", "nginx:1.21", ingressv1beta1.RevisionFailed),
+				revision("c", "nginx:1.22", ingressv1beta1.RevisionFailed),
+			},
+			"nginx:1.20", []string{ReasonProgressDeadlineExceeded, ReasonRolledBack}),
+		table.Entry("automatic rollback without healthy revision",
+			[]ingressv1beta1.AppRevision{revision("a", "nginx:1.20", ingressv1beta1.RevisionProgressing)},
+			ingressv1beta1.AppRollback{Auto: true},
+			newDeployment("a", "nginx:1.20", failed),
+			[]ingressv1beta1.AppRevision{revision("a", "nginx:1.20", ingressv1beta1.RevisionFailed)},
+			"nginx:1.20", []string{ReasonProgressDeadlineExceeded, ReasonRollbackUnavailable}),
+		table.Entry("healthy revision exceeding the progress deadline later",
+			[]ingressv1beta1.AppRevision{revision("a", "nginx:1.20", ingressv1beta1.RevisionHealthy)},
+			ingressv1beta1.AppRollback{Auto: true},
+			newDeployment("a", "nginx:1.20", failed),
+			[]ingressv1beta1.AppRevision{revision("a", "nginx:1.20", ingressv1beta1.RevisionHealthy)},
+			"nginx:1.20", nil),
+	)
+
+	table.DescribeTable("detects the rollouts exceeding their progress deadline",
+		func(update func(d *appsv1.Deployment), expected bool) {
+			d := newDeployment("a", "nginx:1.20", failed)
+			update(d)
+			Expect(progressDeadlineExceeded(d)).To(Equal(expected))
+		},
+		table.Entry("progress deadline exceeded", func(d *appsv1.Deployment) {}, true),
+		table.Entry("new generation not observed", func(d *appsv1.Deployment) { d.Generation++ }, false),
+		table.Entry("progressing", func(d *appsv1.Deployment) {
+			d.Status.Conditions[0] = appsv1.DeploymentCondition{Type: appsv1.DeploymentProgressing,
+				Status: corev1.ConditionTrue, Reason: "ReplicaSetUpdated"}
+		}, false),
+		table.Entry("not progressing for another reason", func(d *appsv1.Deployment) {
+			d.Status.Conditions[0].Reason = "ReplicaSetCreateError"
+		}, false),
+		table.Entry("no Progressing condition", func(d *appsv1.Deployment) { d.Status.Conditions = nil }, false),
+	)
+})
@@ -0,0 +1,427 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	netv1 "k8s.io/api/networking/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/rand"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	ingressv1beta1 "github.com/kubebuilder-demo/api/v1beta1"
+	"github.com/kubebuilder-demo/controllers/utils"
+)
+
+const (
+	// RolloutActionAnnotation promotes or aborts the running canary or
+	// blue-green rollout of an App when set to RolloutActionPromote or
+	// RolloutActionAbort. It is removed once the action has been taken.
+	RolloutActionAnnotation = "rollout.baiding.tech/action"
+
+	// RolloutActionPromote moves a canary rollout to its next step, or
+	// switches a blue-green rollout to the new version.
+	RolloutActionPromote = "promote"
+
+	// RolloutActionAbort removes the new version and keeps the stable one.
+	RolloutActionAbort = "abort"
+
+	// revisionAnnotation records the revision of the Pod template of a Deployment.
+	revisionAnnotation = "rollout.baiding.tech/revision"
+)
+
+// reconcileDeployments creates or updates the stable Deployment of app. When
+// the Pod template changes and app uses the Canary or BlueGreen strategy, it
+// runs the new version in a second Deployment and only updates the stable
+// Deployment once the rollout is promoted. It returns the stable Deployment
+// and when a paused rollout has to be checked again.
+func (r *AppReconciler) reconcileDeployments(ctx context.Context, app *ingressv1beta1.App) (*appsv1.Deployment, time.Duration, error) {
+	configHash, unwatched, err := r.configHash(ctx, app)
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := r.updateConfigCondition(ctx, app, unwatched); err != nil {
+		return nil, 0, err
+	}
+	deployment := utils.NewDeployment(app, configHash)
+	r.fixedReplicas(app, deployment)
+	if err := r.applyOverrides(app, deployment, app.Spec.Overrides.Deployment); err != nil {
+		return nil, 0, err
+	}
+	if err := ctrl.SetControllerReference(app, deployment, r.Scheme); err != nil {
+		return nil, 0, err
+	}
+
+	// 查询是否存在deployment 如果不存在就创建, 如果存在就按照发布策略更新
+	d := &appsv1.Deployment{}
+	err = r.Get(ctx, types.NamespacedName{Namespace: app.Namespace, Name: app.Name}, d)
+	if err != nil && !errors.IsNotFound(err) {
+		return nil, 0, err
+	}
+	// selector不能修改, 之前创建的deployment保持原来的label, 避免重建所有Pod
+	if err == nil && legacySelector(d) {
+		untrackDeployment(deployment, app.Name)
+	}
+	revision := podTemplateRevision(&deployment.Spec.Template)
+	metav1.SetMetaDataAnnotation(&deployment.ObjectMeta, revisionAnnotation, revision)
+	if errors.IsNotFound(err) {
+		if err := r.createOwned(ctx, app, deployment); err != nil {
+			return nil, 0, err
+		}
+		return deployment, 0, r.finishRollout(ctx, app, nil)
+	}
+
+	rollout := app.Status.Rollout
+	stableRevision := d.Annotations[revisionAnnotation]
+	switch {
+	// 滚动更新, 或者deployment还没有记录revision时, 直接更新deployment
+	case app.Spec.Strategy.Type == ingressv1beta1.RollingUpdateStrategyType || app.Spec.Strategy.Type == "" || stableRevision == "":
+		rollout = nil
+	// 没有新版本需要发布, 或者spec改回了稳定版本
+	case stableRevision == revision && !(ingressv1beta1.RolloutInProgress(rollout) && rollout.Phase == ingressv1beta1.RolloutPromoting):
+		if ingressv1beta1.RolloutInProgress(rollout) {
+			rollout = nil
+		}
+	// 中止过的版本不会再次发布, 稳定版本保持不变
+	case rollout != nil && rollout.Phase == ingressv1beta1.RolloutAborted && rollout.UpdateRevision == revision:
+		stable := keepTemplate(deployment, d)
+		if err := r.updateStableDeployment(ctx, app, stable, d); err != nil {
+			return nil, 0, err
+		}
+		return stable, 0, r.finishRollout(ctx, app, rollout)
+	default:
+		return r.progressRollout(ctx, app, deployment, d, revision)
+	}
+	if err := r.updateStableDeployment(ctx, app, deployment, d); err != nil {
+		return nil, 0, err
+	}
+	return deployment, 0, r.finishRollout(ctx, app, rollout)
+}
+
+// updateStableDeployment updates the existing Deployment d to deployment.
+func (r *AppReconciler) updateStableDeployment(ctx context.Context, app *ingressv1beta1.App, deployment, d *appsv1.Deployment) error {
+	// 启用了HPA时副本数由HPA控制, 不覆盖当前的副本数.
+	// 副本数为0时HPA不再扩容, 例如暂停时缩容到0后恢复, 这时从最小副本数开始
+	if app.Spec.Autoscaling != nil && !r.autoscalingUnavailable {
+		deployment.Spec.Replicas = d.Spec.Replicas
+		if d.Spec.Replicas != nil && *d.Spec.Replicas == 0 {
+			deployment.Spec.Replicas = app.Spec.Autoscaling.MinReplicas
+		}
+	}
+	return r.updateOwned(ctx, app, deployment, d)
+}
+
+// keepTemplate returns a copy of deployment with the Pod template and
+// revision of stable, so the stable version keeps running during a rollout.
+func keepTemplate(deployment, stable *appsv1.Deployment) *appsv1.Deployment {
+	d := deployment.DeepCopy()
+	d.Spec.Template = stable.Spec.Template
+	metav1.SetMetaDataAnnotation(&d.ObjectMeta, revisionAnnotation, stable.Annotations[revisionAnnotation])
+	return d
+}
+
+// progressRollout moves the canary or blue-green rollout of the given
+// revision one step further.
+func (r *AppReconciler) progressRollout(ctx context.Context, app *ingressv1beta1.App, deployment, stable *appsv1.Deployment, revision string) (*appsv1.Deployment, time.Duration, error) {
+	now := metav1.Now()
+	rollout := app.Status.Rollout.DeepCopy()
+	if !ingressv1beta1.RolloutInProgress(rollout) || rollout.UpdateRevision != revision {
+		rollout = &ingressv1beta1.AppRolloutStatus{
+			Phase:          ingressv1beta1.RolloutProgressing,
+			StableRevision: stable.Annotations[revisionAnnotation],
+			UpdateRevision: revision,
+			StepStartedAt:  &now,
+		}
+	}
+	configHash := deployment.Spec.Template.Annotations[utils.ConfigHashAnnotation]
+	canary := app.Spec.Strategy.Type == ingressv1beta1.CanaryStrategyType
+	track := utils.TrackPreview
+	if canary {
+		track = utils.TrackCanary
+	}
+
+	action := app.Annotations[RolloutActionAnnotation]
+	if action == RolloutActionAbort {
+		rollout.Phase = ingressv1beta1.RolloutAborted
+		rollout.Message = "aborted by the " + RolloutActionAnnotation + " annotation"
+		if err := r.removeRolloutAction(ctx, app); err != nil {
+			return nil, 0, err
+		}
+		return stable, 0, r.finishRollout(ctx, app, rollout)
+	}
+
+	// 稳定版本的副本数, 启用HPA时以当前的副本数为准
+	total := int32(1)
+	if deployment.Spec.Replicas != nil {
+		total = *deployment.Spec.Replicas
+	} else if stable.Spec.Replicas != nil {
+		total = *stable.Spec.Replicas
+	}
+
+	if rollout.Phase == ingressv1beta1.RolloutPromoting {
+		// 新版本继续接收全部流量, 直到稳定版本更新完成
+		if _, err := r.applyTrack(ctx, app, track, configHash, total, 100, legacySelector(stable)); err != nil {
+			return nil, 0, err
+		}
+		if err := r.updateStableDeployment(ctx, app, deployment, stable); err != nil {
+			return nil, 0, err
+		}
+		if deploymentReady(deployment) {
+			rollout.Phase = ingressv1beta1.RolloutCompleted
+			rollout.StableRevision = revision
+			rollout.Message = ""
+			return deployment, 0, r.finishRollout(ctx, app, rollout)
+		}
+		rollout.Message = fmt.Sprintf("waiting for Deployment %s to be updated", deployment.Name)
+		return deployment, 0, r.setRolloutStatus(ctx, app, rollout)
+	}
+
+	// 发布过程中稳定版本保持原来的Pod模板
+	held := keepTemplate(deployment, stable)
+	if err := r.updateStableDeployment(ctx, app, held, stable); err != nil {
+		return nil, 0, err
+	}
+
+	var steps []ingressv1beta1.AppCanaryStep
+	replicas, weight := total, int32(100)
+	if canary {
+		steps = canarySteps(app)
+		if int(rollout.CurrentStep) >= len(steps) {
+			rollout.CurrentStep = int32(len(steps) - 1)
+		}
+		weight = steps[rollout.CurrentStep].Weight
+		replicas = canaryReplicas(total, weight)
+		rollout.CurrentWeight = weight
+	}
+	second, err := r.applyTrack(ctx, app, track, configHash, replicas, weight, legacySelector(stable))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var requeueAfter time.Duration
+	promote := action == RolloutActionPromote
+	advanced := false
+	switch {
+	case app.Spec.Rollback.Auto && progressDeadlineExceeded(second):
+		rollout.Phase = ingressv1beta1.RolloutAborted
+		rollout.Message = fmt.Sprintf("Deployment %s exceeded its progress deadline", second.Name)
+		r.Recorder.Eventf(app, corev1.EventTypeWarning, ReasonProgressDeadlineExceeded,
+			"Aborted the rollout of revision %s: Deployment %s did not become available within its progress deadline", revision, second.Name)
+		return held, 0, r.finishRollout(ctx, app, rollout)
+	case !deploymentReady(second):
+		// 新版本ready之前不处理promote
+		rollout.Phase = ingressv1beta1.RolloutProgressing
+		rollout.Message = fmt.Sprintf("waiting for Deployment %s to be ready", second.Name)
+		promote = false
+	case !canary:
+		if promote || (app.Spec.Strategy.BlueGreen != nil && app.Spec.Strategy.BlueGreen.AutoPromote) {
+			rollout.Phase = ingressv1beta1.RolloutPromoting
+			rollout.Message = ""
+			advanced = true
+		} else {
+			rollout.Phase = ingressv1beta1.RolloutPaused
+			rollout.Message = "waiting for the " + RolloutActionAnnotation + " annotation to promote"
+		}
+	default:
+		step := steps[rollout.CurrentStep]
+		var remaining time.Duration
+		if step.Pause != nil {
+			remaining = step.Pause.Duration - now.Sub(rollout.StepStartedAt.Time)
+		}
+		switch {
+		case promote || (step.Pause != nil && remaining <= 0):
+			// 进入下一步, 最后一步之后更新稳定版本
+			rollout.CurrentStep++
+			rollout.StepStartedAt = &now
+			advanced = true
+			rollout.Phase = ingressv1beta1.RolloutProgressing
+			rollout.Message = ""
+			if int(rollout.CurrentStep) >= len(steps) {
+				rollout.CurrentStep = int32(len(steps) - 1)
+				rollout.CurrentWeight = 100
+				rollout.Phase = ingressv1beta1.RolloutPromoting
+			}
+		case step.Pause == nil:
+			rollout.Phase = ingressv1beta1.RolloutPaused
+			rollout.Message = fmt.Sprintf("step %d: waiting for the %s annotation to promote", rollout.CurrentStep, RolloutActionAnnotation)
+		default:
+			rollout.Phase = ingressv1beta1.RolloutPaused
+			rollout.Message = fmt.Sprintf("step %d: paused for %s", rollout.CurrentStep, step.Pause.Duration)
+			requeueAfter = remaining
+		}
+	}
+	if promote {
+		if err := r.removeRolloutAction(ctx, app); err != nil {
+			return nil, 0, err
+		}
+	}
+	if err := r.setRolloutStatus(ctx, app, rollout); err != nil {
+		return nil, 0, err
+	}
+	// 立即应用下一步, 只修改App的status不会触发下一次reconcile
+	if advanced {
+		return r.progressRollout(ctx, app, deployment, held, revision)
+	}
+	return held, requeueAfter, nil
+}
+
+// applyTrack creates or updates the Deployment, Service and, for canaries,
+// the Ingress serving the new version of app during a rollout. legacy tells
+// whether the stable Deployment has a selector without the track label.
+func (r *AppReconciler) applyTrack(ctx context.Context, app *ingressv1beta1.App, track, configHash string, replicas, weight int32, legacy bool) (*appsv1.Deployment, error) {
+	deployment := utils.NewTrackDeployment(app, track, configHash)
+	if legacy {
+		untrackDeployment(deployment, app.Name)
+	}
+	if err := r.applyOverrides(app, deployment, app.Spec.Overrides.Deployment); err != nil {
+		return nil, err
+	}
+	deployment.Spec.Replicas = &replicas
+	if err := r.apply(ctx, app, deployment); err != nil {
+		return nil, err
+	}
+
+	name := app.Name + "-" + track
+	if app.Spec.EnableService {
+		service := utils.NewTrackService(app, track)
+		if legacy {
+			utils.UntrackLabels(service.Spec.Selector, app.Name)
+		}
+		if err := r.applyOverrides(app, service, app.Spec.Overrides.Service); err != nil {
+			return nil, err
+		}
+		if err := r.apply(ctx, app, service); err != nil {
+			return nil, err
+		}
+	} else if err := r.deleteIfExists(ctx, app, &corev1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: app.Namespace, Name: name}}); err != nil {
+		return nil, err
+	}
+
+	if track == utils.TrackCanary && app.Spec.EnableIngress {
+		ingress := utils.NewCanaryIngress(app, weight)
+		if err := r.applyOverrides(app, ingress, app.Spec.Overrides.Ingress); err != nil {
+			return nil, err
+		}
+		if err := r.apply(ctx, app, ingress); err != nil {
+			return nil, err
+		}
+	} else if err := r.deleteIfExists(ctx, app, &netv1.Ingress{ObjectMeta: metav1.ObjectMeta{Namespace: app.Namespace, Name: name}}); err != nil {
+		return nil, err
+	}
+	return deployment, nil
+}
+
+// finishRollout removes the resources of the new version and records rollout in the status of app.
+func (r *AppReconciler) finishRollout(ctx context.Context, app *ingressv1beta1.App, rollout *ingressv1beta1.AppRolloutStatus) error {
+	for _, track := range []string{utils.TrackCanary, utils.TrackPreview} {
+		meta := metav1.ObjectMeta{Namespace: app.Namespace, Name: app.Name + "-" + track}
+		for _, obj := range []client.Object{&appsv1.Deployment{ObjectMeta: meta}, &corev1.Service{ObjectMeta: meta}, &netv1.Ingress{ObjectMeta: meta}} {
+			if err := r.deleteIfExists(ctx, app, obj); err != nil {
+				return err
+			}
+		}
+	}
+	return r.setRolloutStatus(ctx, app, rollout)
+}
+
+func (r *AppReconciler) setRolloutStatus(ctx context.Context, app *ingressv1beta1.App, rollout *ingressv1beta1.AppRolloutStatus) error {
+	if apiequality.Semantic.DeepEqual(app.Status.Rollout, rollout) {
+		return nil
+	}
+	app.Status.Rollout = rollout
+	return r.Status().Update(ctx, app)
+}
+
+func (r *AppReconciler) removeRolloutAction(ctx context.Context, app *ingressv1beta1.App) error {
+	delete(app.Annotations, RolloutActionAnnotation)
+	return r.Update(ctx, app)
+}
+
+// apply creates obj owned by app, or updates it if it already exists.
+func (r *AppReconciler) apply(ctx context.Context, app *ingressv1beta1.App, obj client.Object) error {
+	if err := ctrl.SetControllerReference(app, obj, r.Scheme); err != nil {
+		return err
+	}
+	existing := obj.DeepCopyObject().(client.Object)
+	if err := r.Get(ctx, client.ObjectKeyFromObject(obj), existing); err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+		return r.createOwned(ctx, app, obj)
+	}
+	return r.updateOwned(ctx, app, obj, existing)
+}
+
+// deleteIfExists deletes obj owned by app, looking it up in the cache first to avoid
+// needless requests to the API server.
+func (r *AppReconciler) deleteIfExists(ctx context.Context, app *ingressv1beta1.App, obj client.Object) error {
+	if err := r.Get(ctx, client.ObjectKeyFromObject(obj), obj); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	return client.IgnoreNotFound(r.deleteOwned(ctx, app, obj))
+}
+
+// legacySelector returns true for the stable Deployments created before the
+// track label was added to their selector, see utils.UntrackLabels.
+func legacySelector(d *appsv1.Deployment) bool {
+	return d.Spec.Selector != nil && d.Spec.Selector.MatchLabels[utils.TrackLabel] == ""
+}
+
+// untrackDeployment rewrites the selector and the Pod labels of d for an App
+// with a legacy stable Deployment.
+func untrackDeployment(d *appsv1.Deployment, name string) {
+	utils.UntrackLabels(d.Spec.Selector.MatchLabels, name)
+	utils.UntrackLabels(d.Spec.Template.Labels, name)
+}
+
+// legacyStable returns true when the stable Deployment of app exists and has
+// a selector without the track label.
+func (r *AppReconciler) legacyStable(ctx context.Context, app *ingressv1beta1.App) (bool, error) {
+	d := &appsv1.Deployment{}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: app.Namespace, Name: app.Name}, d); err != nil {
+		return false, client.IgnoreNotFound(err)
+	}
+	return legacySelector(d), nil
+}
+
+// canarySteps returns the canary steps of app, or the default single step.
+func canarySteps(app *ingressv1beta1.App) []ingressv1beta1.AppCanaryStep {
+	if app.Spec.Strategy.Canary != nil && len(app.Spec.Strategy.Canary.Steps) > 0 {
+		return app.Spec.Strategy.Canary.Steps
+	}
+	return []ingressv1beta1.AppCanaryStep{{Weight: ingressv1beta1.DefaultCanaryWeight}}
+}
+
+// canaryReplicas returns weight percent of total, rounded up.
+func canaryReplicas(total, weight int32) int32 {
+	return (total*weight + 99) / 100
+}
+
+// deploymentReady returns true once all replicas of d run its current Pod template.
+func deploymentReady(d *appsv1.Deployment) bool {
+	replicas := int32(1)
+	if d.Spec.Replicas != nil {
+		replicas = *d.Spec.Replicas
+	}
+	return d.Status.ObservedGeneration >= d.Generation &&
+		d.Status.Replicas == replicas &&
+		d.Status.UpdatedReplicas == replicas &&
+		d.Status.AvailableReplicas == replicas
+}
+
+// podTemplateRevision returns a short hash identifying template.
+func podTemplateRevision(template *corev1.PodTemplateSpec) string {
+	hasher := fnv.New32a()
+	b, _ := json.Marshal(template)
+	hasher.Write(b)
+	return rand.SafeEncodeString(fmt.Sprint(hasher.Sum32()))
+}
@@ -0,0 +1,69 @@
+package controllers
+
+import (
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	ingressv1beta1 "github.com/kubebuilder-demo/api/v1beta1"
+	"github.com/kubebuilder-demo/controllers/utils"
+)
+
+var _ = Describe("App rollout", func() {
+	newApp := func() *ingressv1beta1.App {
+		app := &ingressv1beta1.App{
+			ObjectMeta: metav1.ObjectMeta{Name: "rollout", Namespace: "default"},
+			Spec: ingressv1beta1.AppSpec{Image: "nginx:1.21", PodDisruptionBudget: &ingressv1beta1.AppPodDisruptionBudget{},
+				Strategy: ingressv1beta1.AppStrategy{Type: ingressv1beta1.CanaryStrategyType}},
+		}
+		app.Default()
+		return app
+	}
+
+	table.DescribeTable("selects the Pods of a single track",
+		func(legacy bool) {
+			app := newApp()
+			deployments := []*appsv1.Deployment{
+				utils.NewDeployment(app, ""),
+				utils.NewTrackDeployment(app, utils.TrackCanary, ""),
+				utils.NewTrackDeployment(app, utils.TrackPreview, ""),
+			}
+			pdb := utils.NewPodDisruptionBudget(app)
+			if legacy {
+				for _, d := range deployments {
+					untrackDeployment(d, app.Name)
+				}
+				utils.UntrackLabels(pdb.Spec.Selector.MatchLabels, app.Name)
+			}
+
+			pdbSelector, err := metav1.LabelSelectorAsSelector(pdb.Spec.Selector)
+			Expect(err).NotTo(HaveOccurred())
+			for i, d := range deployments {
+				selector, err := metav1.LabelSelectorAsSelector(d.Spec.Selector)
+				Expect(err).NotTo(HaveOccurred())
+				for j, other := range deployments {
+					Expect(selector.Matches(labels.Set(other.Spec.Template.Labels))).To(Equal(i == j),
+						"selector of %s matching the Pods of %s", d.Name, other.Name)
+				}
+				Expect(pdbSelector.Matches(labels.Set(d.Spec.Template.Labels))).To(Equal(i == 0),
+					"PodDisruptionBudget selector matching the Pods of %s", d.Name)
+			}
+		},
+		table.Entry("new stable Deployment", false),
+		table.Entry("legacy stable Deployment", true),
+	)
+
+	It("keeps the selector and the Pod labels of legacy stable Deployments", func() {
+		app := newApp()
+		d := utils.NewDeployment(app, "")
+		Expect(legacySelector(d)).To(BeFalse())
+
+		untrackDeployment(d, app.Name)
+		Expect(legacySelector(d)).To(BeTrue())
+		Expect(d.Spec.Selector.MatchLabels).To(Equal(map[string]string{"app": app.Name}))
+		Expect(d.Spec.Template.Labels).To(Equal(map[string]string{"app": app.Name}))
+	})
+})
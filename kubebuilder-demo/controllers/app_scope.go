@@ -0,0 +1,53 @@
+package controllers
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	ingressv1beta1 "github.com/kubebuilder-demo/api/v1beta1"
+)
+
+// inScope reports whether the Apps of namespace are managed by this manager,
+// i.e. whether the labels of namespace match the NamespaceSelector.
+func (r *AppReconciler) inScope(ctx context.Context, namespace string) (bool, error) {
+	if r.NamespaceSelector == nil || r.NamespaceSelector.Empty() {
+		return true, nil
+	}
+	ns := &corev1.Namespace{}
+	if err := r.Get(ctx, types.NamespacedName{Name: namespace}, ns); err != nil {
+		return false, client.IgnoreNotFound(err)
+	}
+	return r.NamespaceSelector.Matches(labels.Set(ns.Labels)), nil
+}
+
+// appInScope filters the events of the Apps whose namespace is not managed by
+// this manager. Apps are kept when the namespace cannot be read, Reconcile
+// checks the scope again.
+func (r *AppReconciler) appInScope(obj client.Object) bool {
+	ok, err := r.inScope(context.Background(), obj.GetNamespace())
+	return ok || err != nil
+}
+
+// appsInNamespace maps a Namespace to its Apps, so that the Apps are
+// reconciled once the labels of their namespace match the NamespaceSelector.
+func (r *AppReconciler) appsInNamespace(obj client.Object) []reconcile.Request {
+	if !r.NamespaceSelector.Matches(labels.Set(obj.GetLabels())) {
+		return nil
+	}
+	apps := &ingressv1beta1.AppList{}
+	if err := r.List(context.Background(), apps, client.InNamespace(obj.GetName())); err != nil {
+		ctrl.Log.WithName("app-controller").Error(err, "unable to list Apps of namespace", "namespace", obj.GetName())
+		return nil
+	}
+	requests := make([]reconcile.Request, 0, len(apps.Items))
+	for _, app := range apps.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: app.Namespace, Name: app.Name}})
+	}
+	return requests
+}
@@ -0,0 +1,87 @@
+package controllers
+
+import (
+	"context"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/pointer"
+
+	ingressv1beta1 "github.com/kubebuilder-demo/api/v1beta1"
+	"github.com/kubebuilder-demo/controllers/utils"
+)
+
+// ReconcileRequestedAtAnnotation forces a reconcile of an App when its value
+// changes, e.g. to the current time. The value is recorded in the
+// LastHandledReconcileAt status field once the reconcile succeeded.
+const ReconcileRequestedAtAnnotation = "reconcile.baiding.tech/requestedAt"
+
+// Reasons of the Suspended condition and Events of an App.
+const (
+	ReasonSuspended = "Suspended"
+	ReasonResumed   = "Resumed"
+)
+
+// reconcileSuspended reports app as suspended and scales its Deployments to
+// zero when requested. The other objects owned by app are left unchanged.
+func (r *AppReconciler) reconcileSuspended(ctx context.Context, app *ingressv1beta1.App) error {
+	if app.Spec.Suspend.ScaleToZero {
+		for _, name := range []string{app.Name, app.Name + "-" + utils.TrackCanary, app.Name + "-" + utils.TrackPreview} {
+			d := &appsv1.Deployment{}
+			if err := r.Get(ctx, types.NamespacedName{Namespace: app.Namespace, Name: name}, d); err != nil {
+				if errors.IsNotFound(err) {
+					continue
+				}
+				return err
+			}
+			if !metav1.IsControlledBy(d, app) || (d.Spec.Replicas != nil && *d.Spec.Replicas == 0) {
+				continue
+			}
+			scaled := d.DeepCopy()
+			scaled.Spec.Replicas = pointer.Int32(0)
+			if err := r.updateOwned(ctx, app, scaled, d); err != nil {
+				return err
+			}
+		}
+	}
+	return r.updateSuspendedCondition(ctx, app)
+}
+
+// updateSuspendedCondition reports in the status of app whether it is
+// suspended. The condition is only added once app has been suspended.
+func (r *AppReconciler) updateSuspendedCondition(ctx context.Context, app *ingressv1beta1.App) error {
+	condition := metav1.Condition{
+		Type:               ingressv1beta1.ConditionSuspended,
+		Status:             metav1.ConditionTrue,
+		Reason:             ReasonSuspended,
+		Message:            "The objects owned by the App are not updated",
+		ObservedGeneration: app.Generation,
+	}
+	existing := meta.FindStatusCondition(app.Status.Conditions, ingressv1beta1.ConditionSuspended)
+	switch {
+	case app.Spec.Suspend == nil && existing == nil:
+		return nil
+	case app.Spec.Suspend == nil:
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = ReasonResumed
+		condition.Message = "The objects owned by the App are updated"
+	case app.Spec.Suspend.ScaleToZero:
+		condition.Message = "The Deployments of the App are scaled to zero, the other objects owned by the App are not updated"
+	}
+	return r.setCondition(ctx, app, condition, corev1.EventTypeNormal)
+}
+
+// markReconcileHandled records in the status of app that the reconcile
+// requested with the ReconcileRequestedAtAnnotation has been handled.
+func (r *AppReconciler) markReconcileHandled(ctx context.Context, app *ingressv1beta1.App) error {
+	requestedAt, ok := app.Annotations[ReconcileRequestedAtAnnotation]
+	if !ok || app.Status.LastHandledReconcileAt == requestedAt {
+		return nil
+	}
+	app.Status.LastHandledReconcileAt = requestedAt
+	return r.Status().Update(ctx, app)
+}
@@ -1,51 +1,148 @@
 package utils
 
 import (
-	"bytes"
+	"encoding/json"
+	"fmt"
 	"github.com/kubebuilder-demo/api/v1beta1"
+	"hash/fnv"
 	appv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
 	corev1 "k8s.io/api/core/v1"
 	netv1 "k8s.io/api/networking/v1"
-	"k8s.io/apimachinery/pkg/util/yaml"
-	"text/template"
+	policyv1 "k8s.io/api/policy/v1"
+	"k8s.io/apimachinery/pkg/util/rand"
+	"strconv"
 )
 
-func parseTemplate(templateName string, app *v1beta1.App) []byte {
-	tmpl, err := template.ParseFiles("controllers/template/" + templateName + ".yml")
+// ConfigHashAnnotation is set on the Pod template to the hash of the data of
+// the ConfigMaps and Secrets used by an App, so changes restart the Pods.
+const ConfigHashAnnotation = v1beta1.ConfigHashAnnotation
+
+// Pod的track label区分稳定版本和发布中的新版本
+const (
+	TrackLabel   = v1beta1.TrackLabel
+	TrackStable  = v1beta1.TrackStable
+	TrackCanary  = v1beta1.TrackCanary
+	TrackPreview = v1beta1.TrackPreview
+)
+
+// NewDeployment returns the Deployment of an App. configHash is the hash of
+// the ConfigMaps and Secrets used by the App, see ConfigHash.
+func NewDeployment(app *v1beta1.App, configHash string) *appv1.Deployment {
+	d, err := v1beta1.RenderDeployment(app, configHash)
 	if err != nil {
 		panic(err)
 	}
-	b := new(bytes.Buffer)
-	err = tmpl.Execute(b, app)
+	return d
+}
+
+func NewIngress(app *v1beta1.App) *netv1.Ingress {
+	i, err := v1beta1.RenderIngress(app)
 	if err != nil {
 		panic(err)
 	}
-	return b.Bytes()
+	return i
 }
 
-func NewDeployment(app *v1beta1.App) *appv1.Deployment {
-	d := &appv1.Deployment{}
-	err := yaml.Unmarshal(parseTemplate("deployment", app), d)
+func NewService(app *v1beta1.App) *corev1.Service {
+	s, err := v1beta1.RenderService(app)
 	if err != nil {
 		panic(err)
 	}
+	return s
+}
+
+// NewTrackDeployment returns the Deployment <name>-<track> running the
+// current spec of an App next to the stable Deployment during a rollout.
+func NewTrackDeployment(app *v1beta1.App, track, configHash string) *appv1.Deployment {
+	d := NewDeployment(app, configHash)
+	d.Name = app.Name + "-" + track
+	d.Spec.Selector.MatchLabels[TrackLabel] = track
+	d.Spec.Template.Labels[TrackLabel] = track
 	return d
 }
 
-func NewIngress(app *v1beta1.App) *netv1.Ingress {
-	i := &netv1.Ingress{}
-	err := yaml.Unmarshal(parseTemplate("ingress", app), i)
+// UntrackLabels rewrites the labels or the selector labels of a track for an
+// App whose stable Deployment was created before the track label was part of
+// its selector. The selector of a Deployment can not be changed and labeling
+// the stable Pods would restart them, so the stable Pods keep the app label
+// only and the Pods of the other tracks are labeled app=<name>-<track>
+// instead, which the stable selector does not match.
+func UntrackLabels(labels map[string]string, name string) {
+	switch track := labels[TrackLabel]; track {
+	case "":
+	case TrackStable:
+		delete(labels, TrackLabel)
+	default:
+		labels["app"] = name + "-" + track
+	}
+}
+
+// NewTrackService returns the Service <name>-<track> selecting only the Pods of track.
+func NewTrackService(app *v1beta1.App, track string) *corev1.Service {
+	s := NewService(app)
+	s.Name = app.Name + "-" + track
+	s.Spec.Selector[TrackLabel] = track
+	return s
+}
+
+// NewCanaryIngress returns the Ingress sending weight percent of the traffic
+// to the canary Service, using the canary annotations of ingress-nginx.
+func NewCanaryIngress(app *v1beta1.App, weight int32) *netv1.Ingress {
+	i := NewIngress(app)
+	i.Name = app.Name + "-" + TrackCanary
+	if i.Annotations == nil {
+		i.Annotations = map[string]string{}
+	}
+	i.Annotations["nginx.ingress.kubernetes.io/canary"] = "true"
+	i.Annotations["nginx.ingress.kubernetes.io/canary-weight"] = strconv.Itoa(int(weight))
+	for _, rule := range i.Spec.Rules {
+		for j := range rule.HTTP.Paths {
+			rule.HTTP.Paths[j].Backend.Service.Name = i.Name
+		}
+	}
+	return i
+}
+
+// NewHorizontalPodAutoscaler returns the HPA of an App with autoscaling enabled.
+func NewHorizontalPodAutoscaler(app *v1beta1.App) *autoscalingv2.HorizontalPodAutoscaler {
+	h, err := v1beta1.RenderHorizontalPodAutoscaler(app)
 	if err != nil {
 		panic(err)
 	}
-	return i
+	return h
 }
 
-func NewService(app *v1beta1.App) *corev1.Service {
-	s := &corev1.Service{}
-	err := yaml.Unmarshal(parseTemplate("service", app), s)
+// NewPodDisruptionBudget returns the PodDisruptionBudget of an App.
+func NewPodDisruptionBudget(app *v1beta1.App) *policyv1.PodDisruptionBudget {
+	p, err := v1beta1.RenderPodDisruptionBudget(app)
 	if err != nil {
 		panic(err)
 	}
-	return s
+	return p
+}
+
+// ConfigHash returns a hash of the data of the given ConfigMaps and Secrets.
+// A nil entry stands for a missing object.
+func ConfigHash(configMaps []*corev1.ConfigMap, secrets []*corev1.Secret) string {
+	hasher := fnv.New32a()
+	for _, cm := range configMaps {
+		if cm == nil {
+			hasher.Write([]byte("configmap:-\n"))
+			continue
+		}
+		b, _ := json.Marshal([]interface{}{cm.Name, cm.Data, cm.BinaryData})
+		hasher.Write([]byte("configmap:"))
+		hasher.Write(b)
+	}
+	for _, secret := range secrets {
+		if secret == nil {
+			hasher.Write([]byte("secret:-\n"))
+			continue
+		}
+		b, _ := json.Marshal([]interface{}{secret.Name, secret.Data})
+		hasher.Write([]byte("secret:"))
+		hasher.Write(b)
+	}
+	return rand.SafeEncodeString(fmt.Sprint(hasher.Sum32()))
 }
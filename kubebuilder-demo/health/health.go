@@ -0,0 +1,107 @@
+// Package health provides the readiness checks of the manager, so that
+// Kubernetes only routes webhook traffic to replicas able to serve it.
+package health
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+// Timeout bounds the time a single check waits for the cache or the webhook server.
+var Timeout = time.Second
+
+// CacheSynced returns a checker failing until the informers of c have synced.
+func CacheSynced(c cache.Cache) healthz.Checker {
+	return func(req *http.Request) error {
+		ctx, cancel := context.WithTimeout(req.Context(), Timeout)
+		defer cancel()
+		if !c.WaitForCacheSync(ctx) {
+			return errors.New("informer caches are not synced")
+		}
+		return nil
+	}
+}
+
+// WebhookCertificate returns a checker failing while the serving certificate
+// of server is missing, not yet valid or expired. The certificate is read on
+// every check, so that a rotated certificate is taken into account.
+func WebhookCertificate(server *webhook.Server) healthz.Checker {
+	certDir, certName := server.CertDir, server.CertName
+	if certDir == "" {
+		certDir = filepath.Join(os.TempDir(), "k8s-webhook-server", "serving-certs")
+	}
+	if certName == "" {
+		certName = "tls.crt"
+	}
+	certPath := filepath.Join(certDir, certName)
+	return func(_ *http.Request) error {
+		data, err := os.ReadFile(certPath)
+		if err != nil {
+			return fmt.Errorf("unable to read the webhook certificate: %w", err)
+		}
+		block, _ := pem.Decode(data)
+		if block == nil || block.Type != "CERTIFICATE" {
+			return fmt.Errorf("%s does not contain a PEM encoded certificate", certPath)
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return fmt.Errorf("unable to parse the webhook certificate: %w", err)
+		}
+		now := time.Now()
+		if now.Before(cert.NotBefore) {
+			return fmt.Errorf("the webhook certificate is not valid before %s", cert.NotBefore)
+		}
+		if now.After(cert.NotAfter) {
+			return fmt.Errorf("the webhook certificate expired at %s", cert.NotAfter)
+		}
+		return nil
+	}
+}
+
+// WebhookServing returns a checker failing until server accepts TLS connections.
+func WebhookServing(server *webhook.Server) healthz.Checker {
+	host, port := server.Host, server.Port
+	if host == "" {
+		host = "localhost"
+	}
+	if port <= 0 {
+		port = webhook.DefaultPort
+	}
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
+	return func(_ *http.Request) error {
+		dialer := &net.Dialer{Timeout: Timeout}
+		// 只检查是否完成了TLS握手, 证书的有效性由WebhookCertificate检查
+		conn, err := tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{InsecureSkipVerify: true}) //nolint:gosec
+		if err != nil {
+			return fmt.Errorf("webhook server is not serving: %w", err)
+		}
+		return conn.Close()
+	}
+}
+
+// Leader returns a checker failing until elected is closed, i.e. until this
+// replica is leading. See manager.Manager.Elected.
+func Leader(elected <-chan struct{}) healthz.Checker {
+	return func(_ *http.Request) error {
+		select {
+		case <-elected:
+			return nil
+		default:
+			return errors.New("this replica is not leading")
+		}
+	}
+}
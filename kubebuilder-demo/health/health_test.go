@@ -0,0 +1,117 @@
+package health
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+// writeCertificate writes a self-signed certificate valid between notBefore
+// and notAfter and its key to dir.
+func writeCertificate(dir string, notBefore, notAfter time.Time) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).NotTo(HaveOccurred())
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	Expect(err).NotTo(HaveOccurred())
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	Expect(err).NotTo(HaveOccurred())
+	Expect(os.WriteFile(filepath.Join(dir, "tls.crt"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)).To(Succeed())
+	Expect(os.WriteFile(filepath.Join(dir, "tls.key"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)).To(Succeed())
+}
+
+var _ = Describe("Readiness checks", func() {
+	var certDir string
+
+	BeforeEach(func() {
+		var err error
+		certDir, err = os.MkdirTemp("", "health")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(certDir)).To(Succeed())
+	})
+
+	Context("webhook certificate", func() {
+		It("succeeds for a valid certificate", func() {
+			writeCertificate(certDir, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+			Expect(WebhookCertificate(&webhook.Server{CertDir: certDir})(nil)).To(Succeed())
+		})
+
+		It("fails for a missing certificate", func() {
+			Expect(WebhookCertificate(&webhook.Server{CertDir: certDir})(nil)).To(MatchError(ContainSubstring("unable to read")))
+		})
+
+		It("fails for an expired certificate", func() {
+			writeCertificate(certDir, time.Now().Add(-2*time.Hour), time.Now().Add(-time.Hour))
+			Expect(WebhookCertificate(&webhook.Server{CertDir: certDir})(nil)).To(MatchError(ContainSubstring("expired")))
+		})
+
+		It("fails for a certificate that is not valid yet", func() {
+			writeCertificate(certDir, time.Now().Add(time.Hour), time.Now().Add(2*time.Hour))
+			Expect(WebhookCertificate(&webhook.Server{CertDir: certDir})(nil)).To(MatchError(ContainSubstring("not valid before")))
+		})
+	})
+
+	Context("webhook serving", func() {
+		It("succeeds once the server accepts TLS connections", func() {
+			writeCertificate(certDir, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+			cert, err := tls.LoadX509KeyPair(filepath.Join(certDir, "tls.crt"), filepath.Join(certDir, "tls.key"))
+			Expect(err).NotTo(HaveOccurred())
+			listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+			Expect(err).NotTo(HaveOccurred())
+			defer listener.Close()
+			go func() {
+				defer GinkgoRecover()
+				for {
+					conn, err := listener.Accept()
+					if err != nil {
+						return
+					}
+					_ = conn.(*tls.Conn).Handshake()
+					conn.Close()
+				}
+			}()
+
+			port := listener.Addr().(*net.TCPAddr).Port
+			Expect(WebhookServing(&webhook.Server{Host: "127.0.0.1", Port: port})(nil)).To(Succeed())
+		})
+
+		It("fails while nothing listens", func() {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).NotTo(HaveOccurred())
+			port := listener.Addr().(*net.TCPAddr).Port
+			Expect(listener.Close()).To(Succeed())
+
+			Expect(WebhookServing(&webhook.Server{Host: "127.0.0.1", Port: port})(nil)).To(MatchError(ContainSubstring("not serving")))
+		})
+	})
+
+	It("reports ready only once elected", func() {
+		elected := make(chan struct{})
+		check := Leader(elected)
+		Expect(check(nil)).To(MatchError(ContainSubstring("not leading")))
+		close(elected)
+		Expect(check(nil)).To(Succeed())
+	})
+})
@@ -17,22 +17,34 @@ limitations under the License.
 package main
 
 import (
+	"errors"
 	"flag"
 	"os"
+	"strings"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
 
+	configv1alpha1 "github.com/kubebuilder-demo/api/config/v1alpha1"
+	ingressv1 "github.com/kubebuilder-demo/api/v1"
 	ingressv1beta1 "github.com/kubebuilder-demo/api/v1beta1"
+	"github.com/kubebuilder-demo/certs"
 	"github.com/kubebuilder-demo/controllers"
+	"github.com/kubebuilder-demo/health"
 	//+kubebuilder:scaffold:imports
 )
 
@@ -43,21 +55,42 @@ var (
 
 func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(apiextensionsv1.AddToScheme(scheme))
 
 	utilruntime.Must(ingressv1beta1.AddToScheme(scheme))
+	utilruntime.Must(ingressv1.AddToScheme(scheme))
+	utilruntime.Must(configv1alpha1.AddToScheme(scheme))
 	//+kubebuilder:scaffold:scheme
 }
 
 func main() {
 
+	var configFile string
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var ingressDomain string
+	var ingressClass string
+	var maxConcurrentReconciles int
+	var namespaces string
+	var namespaceSelector string
+	flag.StringVar(&configFile, "config", "",
+		"The manager loads its configuration from this file. "+
+			"Flags set on the command line override the values of the file.")
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.StringVar(&ingressDomain, "ingress-domain", ingressv1beta1.IngressDomain,
+		"The domain used to build the default Ingress host <name>.<domain> of an App.")
+	flag.StringVar(&ingressClass, "ingress-class", "",
+		"The IngressClass of the Apps that do not set one. The cluster default class is used when empty.")
+	flag.IntVar(&maxConcurrentReconciles, "max-concurrent-reconciles", 1, "The number of Apps reconciled concurrently.")
+	flag.StringVar(&namespaces, "namespaces", "",
+		"Comma separated list of the namespaces watched by the manager. All namespaces are watched when empty.")
+	flag.StringVar(&namespaceSelector, "namespace-selector", "",
+		"Label selector of the namespaces whose Apps are managed by the manager, e.g. tenant=a.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -66,14 +99,99 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
-	options := ctrl.Options{
-		Scheme:                 scheme,
-		MetricsBindAddress:     metricsAddr,
-		Port:                   9443,
-		HealthProbeBindAddress: probeAddr,
-		LeaderElection:         enableLeaderElection,
-		LeaderElectionID:       "94ba189a.baiding.tech",
+	var err error
+	managerConfig := configv1alpha1.AppManagerConfig{}
+	options := ctrl.Options{Scheme: scheme}
+	if configFile != "" {
+		options, err = options.AndFrom(ctrl.ConfigFile().AtPath(configFile).OfKind(&managerConfig))
+		if err != nil {
+			setupLog.Error(err, "unable to load the config file", "file", configFile)
+			os.Exit(1)
+		}
+	}
+	// 命令行中设置的flag覆盖配置文件, 配置文件中没有的值使用flag的默认值
+	setFlags := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { setFlags[f.Name] = true })
+	if setFlags["metrics-bind-address"] || options.MetricsBindAddress == "" {
+		options.MetricsBindAddress = metricsAddr
+	}
+	if setFlags["health-probe-bind-address"] || options.HealthProbeBindAddress == "" {
+		options.HealthProbeBindAddress = probeAddr
+	}
+	if setFlags["leader-elect"] {
+		options.LeaderElection = enableLeaderElection
+	}
+	if setFlags["ingress-domain"] || managerConfig.Ingress.Domain == "" {
+		managerConfig.Ingress.Domain = ingressDomain
+	}
+	if setFlags["ingress-class"] {
+		managerConfig.Ingress.ClassName = ingressClass
+	}
+	if setFlags["max-concurrent-reconciles"] || managerConfig.MaxConcurrentReconciles == 0 {
+		managerConfig.MaxConcurrentReconciles = maxConcurrentReconciles
+	}
+	if setFlags["namespaces"] {
+		managerConfig.Namespaces = nil
+		if namespaces != "" {
+			managerConfig.Namespaces = strings.Split(namespaces, ",")
+		}
+	}
+	if setFlags["namespace-selector"] {
+		selector, err := metav1.ParseToLabelSelector(namespaceSelector)
+		if err != nil {
+			setupLog.Error(err, "invalid namespace selector", "selector", namespaceSelector)
+			os.Exit(1)
+		}
+		managerConfig.NamespaceSelector = selector
+	}
+	if err := managerConfig.Validate(); err != nil {
+		setupLog.Error(err, "invalid configuration")
+		os.Exit(1)
+	}
+
+	if options.Port == 0 {
+		options.Port = 9443
+	}
+	if options.LeaderElectionID == "" {
+		options.LeaderElectionID = "94ba189a.baiding.tech"
+	}
+	// 退出时主动释放leader, 新的副本不需要等待lease过期才能ready
+	options.LeaderElectionReleaseOnCancel = true
+	switch len(managerConfig.Namespaces) {
+	case 0:
+	case 1:
+		options.Namespace = managerConfig.Namespaces[0]
+	default:
+		options.NewCache = cache.MultiNamespacedCacheBuilder(managerConfig.Namespaces)
 	}
+	// 只缓存App使用的ConfigMap和Secret
+	if options.NewCache == nil {
+		options.NewCache = cache.New
+	}
+	options.NewCache = controllers.ConfigCache(options.NewCache)
+	var appNamespaceSelector labels.Selector
+	if managerConfig.NamespaceSelector != nil {
+		appNamespaceSelector, err = metav1.LabelSelectorAsSelector(managerConfig.NamespaceSelector)
+		if err != nil {
+			setupLog.Error(err, "invalid namespace selector")
+			os.Exit(1)
+		}
+	}
+	if managerConfig.ProtectedNamespaceSelector != nil {
+		ingressv1beta1.ProtectedNamespaceSelector, err = metav1.LabelSelectorAsSelector(managerConfig.ProtectedNamespaceSelector)
+		if err != nil {
+			setupLog.Error(err, "invalid protected namespace selector")
+			os.Exit(1)
+		}
+	}
+	// 不过滤事件的watch配置为空的predicate列表
+	watchPredicates := map[string][]predicate.Predicate{}
+	for _, watch := range managerConfig.UnfilteredWatches {
+		watchPredicates[watch] = nil
+	}
+	ingressv1beta1.IngressDomain = managerConfig.Ingress.Domain
+	ingressv1beta1.IngressClassName = managerConfig.Ingress.ClassName
+
 	if os.Getenv("ENVIRONMENT") == "DEV" {
 		path, err := os.Getwd()
 		if err != nil {
@@ -89,8 +207,14 @@ func main() {
 	}
 
 	if err = (&controllers.AppReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:    mgr.GetClient(),
+		Scheme:    mgr.GetScheme(),
+		Recorder:  mgr.GetEventRecorderFor("app-controller"),
+		APIReader: mgr.GetAPIReader(),
+
+		MaxConcurrentReconciles: managerConfig.MaxConcurrentReconciles,
+		NamespaceSelector:       appNamespaceSelector,
+		WatchPredicates:         watchPredicates,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "App")
 		os.Exit(1)
@@ -101,18 +225,79 @@ func main() {
 	}
 	//+kubebuilder:scaffold:builder
 
+	ctx := ctrl.SetupSignalHandler()
+	if managerConfig.Certificates != nil {
+		certManager, err := newCertificateManager(mgr, managerConfig.Certificates)
+		if err != nil {
+			setupLog.Error(err, "unable to set up the webhook certificates")
+			os.Exit(1)
+		}
+		// webhook server启动时证书必须已经存在
+		if err := certManager.Ensure(ctx); err != nil {
+			setupLog.Error(err, "unable to create the webhook certificates")
+			os.Exit(1)
+		}
+		if err := mgr.Add(certManager); err != nil {
+			setupLog.Error(err, "unable to set up the webhook certificates")
+			os.Exit(1)
+		}
+	}
+
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
 		setupLog.Error(err, "unable to set up health check")
 		os.Exit(1)
 	}
-	if err := mgr.AddReadyzCheck("readyz", healthz.Ping); err != nil {
-		setupLog.Error(err, "unable to set up ready check")
-		os.Exit(1)
+	// 只有cache同步完成, webhook可以正常服务并且(启用选主时)是leader的副本才ready
+	readyzChecks := map[string]healthz.Checker{
+		"cache":               health.CacheSynced(mgr.GetCache()),
+		"webhook-certificate": health.WebhookCertificate(mgr.GetWebhookServer()),
+		"webhook":             health.WebhookServing(mgr.GetWebhookServer()),
+	}
+	if options.LeaderElection {
+		readyzChecks["leader"] = health.Leader(mgr.Elected())
+	}
+	for name, check := range readyzChecks {
+		if err := mgr.AddReadyzCheck(name, check); err != nil {
+			setupLog.Error(err, "unable to set up ready check", "check", name)
+			os.Exit(1)
+		}
 	}
 
 	setupLog.Info("starting manager")
-	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
+	if err := mgr.Start(ctx); err != nil {
 		setupLog.Error(err, "problem running manager")
 		os.Exit(1)
 	}
 }
+
+// newCertificateManager returns the manager of the webhook certificates configured by config.
+func newCertificateManager(mgr ctrl.Manager, config *configv1alpha1.CertificatesConfig) (*certs.Manager, error) {
+	namespace := config.SecretNamespace
+	if namespace == "" {
+		namespace = os.Getenv("POD_NAMESPACE")
+	}
+	if namespace == "" {
+		return nil, errors.New("the namespace of the certificates is neither configured nor set in POD_NAMESPACE")
+	}
+	certManager := &certs.Manager{
+		Client:                          mgr.GetClient(),
+		Reader:                          mgr.GetAPIReader(),
+		Secret:                          types.NamespacedName{Namespace: namespace, Name: config.SecretName},
+		CertDir:                         mgr.GetWebhookServer().CertDir,
+		Hosts:                           config.Hosts,
+		MutatingWebhookConfigurations:   config.MutatingWebhookConfigurations,
+		ValidatingWebhookConfigurations: config.ValidatingWebhookConfigurations,
+		CustomResourceDefinitions:       config.CustomResourceDefinitions,
+	}
+	if config.ServiceName != "" {
+		service := config.ServiceName + "." + namespace + ".svc"
+		certManager.Hosts = append([]string{service, service + ".cluster.local"}, certManager.Hosts...)
+	}
+	if config.Validity != nil {
+		certManager.Validity = config.Validity.Duration
+	}
+	if config.RotateBefore != nil {
+		certManager.RotateBefore = config.RotateBefore.Duration
+	}
+	return certManager, nil
+}
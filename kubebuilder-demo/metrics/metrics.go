@@ -0,0 +1,194 @@
+// Package metrics defines the App specific metrics of the manager. They are
+// registered in the controller-runtime registry and served with the default
+// controller-runtime metrics on the metrics endpoint.
+package metrics
+
+import (
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const namespace = "app_operator"
+
+// Phases of an App reconcile.
+const (
+	PhaseDeployment = "deployment"
+	PhaseService    = "service"
+	PhaseIngress    = "ingress"
+)
+
+// Results of an operation on a resource owned by an App.
+const (
+	ResultSuccess = "success"
+	ResultError   = "error"
+)
+
+// Results of an update of a resource owned by an App.
+const (
+	UpdateApplied = "applied"
+	UpdateSkipped = "skipped"
+)
+
+// Decisions of the validating webhook.
+const (
+	DecisionAllowed = "allowed"
+	DecisionDenied  = "denied"
+)
+
+var (
+	// apps counts the Apps per namespace and readiness state.
+	apps = newAppCollector()
+
+	// ReconcilePhaseDuration observes the duration of the successful phases of
+	// an App reconcile.
+	ReconcilePhaseDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "reconcile_phase_duration_seconds",
+		Help:      "Duration of the successful phases of an App reconcile.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 14),
+	}, []string{"phase"})
+
+	// OwnedResourceOperations counts the create, update and delete operations
+	// on the resources owned by Apps.
+	OwnedResourceOperations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "owned_resource_operations_total",
+		Help:      "Number of create, update and delete operations on the resources owned by Apps.",
+	}, []string{"kind", "operation", "result"})
+
+	// OwnedResourceUpdates counts the updates of the resources owned by Apps,
+	// applied or skipped because the resource did not drift from its desired state.
+	OwnedResourceUpdates = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "owned_resource_updates_total",
+		Help:      "Number of updates of the resources owned by Apps, applied or skipped when the resource did not drift.",
+	}, []string{"kind", "result"})
+
+	// WebhookAdmissions counts the decisions of the validating webhook. Denied
+	// requests are labeled with the type and field of their first error.
+	WebhookAdmissions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "webhook_admissions_total",
+		Help:      "Number of App admission decisions of the validating webhook.",
+	}, []string{"operation", "decision", "reason", "field"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(apps, ReconcilePhaseDuration, OwnedResourceOperations, OwnedResourceUpdates, WebhookAdmissions)
+}
+
+// SetAppReady records whether the App key is ready.
+func SetAppReady(key types.NamespacedName, ready bool) {
+	apps.set(key, ready)
+}
+
+// ForgetApp stops counting the App key, e.g. once it is deleted.
+func ForgetApp(key types.NamespacedName) {
+	apps.forget(key)
+}
+
+// ObserveReconcilePhase records the duration of phase, started at start.
+func ObserveReconcilePhase(phase string, start time.Time) {
+	ReconcilePhaseDuration.WithLabelValues(phase).Observe(time.Since(start).Seconds())
+}
+
+// RecordOwnedResourceOperation counts an operation on a resource of kind
+// owned by an App, err being the result of the operation.
+func RecordOwnedResourceOperation(kind, operation string, err error) {
+	result := ResultSuccess
+	if err != nil {
+		result = ResultError
+	}
+	OwnedResourceOperations.WithLabelValues(kind, operation, result).Inc()
+}
+
+// RecordOwnedResourceUpdate counts an update of a resource of kind owned by
+// an App, applied or skipped.
+func RecordOwnedResourceUpdate(kind string, applied bool) {
+	result := UpdateSkipped
+	if applied {
+		result = UpdateApplied
+	}
+	OwnedResourceUpdates.WithLabelValues(kind, result).Inc()
+}
+
+var indexRegexp = regexp.MustCompile(`\[[^]]*\]`)
+
+// RecordAdmission counts the decision of the validating webhook for an
+// operation, allErrs being the validation errors of the App.
+func RecordAdmission(operation string, allErrs field.ErrorList) {
+	if len(allErrs) == 0 {
+		WebhookAdmissions.WithLabelValues(operation, DecisionAllowed, "", "").Inc()
+		return
+	}
+	// 去掉字段路径中的下标和key, 避免label的取值过多
+	err := allErrs[0]
+	WebhookAdmissions.WithLabelValues(operation, DecisionDenied, string(err.Type), indexRegexp.ReplaceAllString(err.Field, "")).Inc()
+}
+
+// appCollector reports the number of Apps per namespace and readiness state
+// from the readiness recorded by the reconciler.
+type appCollector struct {
+	desc *prometheus.Desc
+
+	mu    sync.Mutex
+	ready map[types.NamespacedName]bool
+}
+
+func newAppCollector() *appCollector {
+	return &appCollector{
+		desc: prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "apps"),
+			"Number of Apps per namespace and readiness state.", []string{"namespace", "ready"}, nil),
+		ready: map[types.NamespacedName]bool{},
+	}
+}
+
+func (c *appCollector) set(key types.NamespacedName, ready bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ready[key] = ready
+}
+
+func (c *appCollector) forget(key types.NamespacedName) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.ready, key)
+}
+
+// Describe implements prometheus.Collector.
+func (c *appCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+// Collect implements prometheus.Collector.
+func (c *appCollector) Collect(ch chan<- prometheus.Metric) {
+	type state struct {
+		namespace string
+		ready     bool
+	}
+	c.mu.Lock()
+	counts := map[state]int{}
+	for key, ready := range c.ready {
+		counts[state{key.Namespace, ready}]++
+	}
+	c.mu.Unlock()
+
+	// 没有ready或没有未ready的App时也上报0, 方便按namespace计算比例
+	namespaces := map[string]bool{}
+	for s := range counts {
+		namespaces[s.namespace] = true
+	}
+	for ns := range namespaces {
+		for _, ready := range []bool{true, false} {
+			ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue,
+				float64(counts[state{ns, ready}]), ns, strconv.FormatBool(ready))
+		}
+	}
+}